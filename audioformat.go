@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// AudioFormatPolicy picks the stream to download: the lowest or highest bitrate
+// or the one closest to TgAudioBitrate, among the preferred container first,
+// the audio track in the preferred language or the original one, and the audio only streams before the muxed ones.
+type AudioFormatPolicy struct {
+	Select   string `yaml:"select"`
+	Prefer   string `yaml:"prefer"`
+	Language string `yaml:"language"`
+}
+
+var (
+	YtAudioSelect   string
+	YtAudioPrefer   string
+	YtAudioLanguage string
+)
+
+func (p *AudioFormatPolicy) check() error {
+	switch p.Select {
+	case "":
+		p.Select = "lowest"
+	case "lowest", "highest", "closest":
+	default:
+		return fmt.Errorf("audio format select %#v: expected lowest, highest or closest", p.Select)
+	}
+	switch p.Prefer {
+	case "":
+		p.Prefer = "mp4"
+	case "mp4", "opus", "any":
+	default:
+		return fmt.Errorf("audio format prefer %#v: expected mp4, opus or any", p.Prefer)
+	}
+	return nil
+}
+
+func (p AudioFormatPolicy) String() string {
+	s := p.Select + " " + p.Prefer
+	if p.Language != "" {
+		s += " lang:" + p.Language
+	}
+	return s
+}
+
+// formatTags returns the xtags of the stream url, like acont=original:lang=en-US for the videos with several audio tracks.
+func formatTags(f yt.Format) map[string]string {
+	streamUrl := f.URL
+	if streamUrl == "" && f.Cipher != "" {
+		if cipher, err := url.ParseQuery(f.Cipher); err == nil {
+			streamUrl = cipher.Get("url")
+		}
+	}
+	u, err := url.Parse(streamUrl)
+	if err != nil {
+		return nil
+	}
+	tags := make(map[string]string)
+	for _, kv := range strings.Split(u.Query().Get("xtags"), ":") {
+		if i := strings.Index(kv, "="); i > 0 {
+			tags[kv[:i]] = kv[i+1:]
+		}
+	}
+	return tags
+}
+
+func formatBitrate(f yt.Format) int {
+	if f.AverageBitrate > 0 {
+		return f.AverageBitrate
+	}
+	return f.Bitrate
+}
+
+// parseBitrate parses the ffmpeg style bitrates like 96k to bits per second.
+func parseBitrate(s string) (int, error) {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "k"):
+		mult, s = 1000, strings.TrimSuffix(s, "k")
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1000*1000, strings.TrimSuffix(s, "M")
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bitrate %#v: %v", s, err)
+	}
+	return int(n * float64(mult)), nil
+}
+
+func describeFormat(f yt.Format) string {
+	s := fmt.Sprintf("itag:%d %s bitrate:%dkbps", f.ItagNo, f.MimeType, formatBitrate(f)/1024)
+	if lang := formatTags(f)["lang"]; lang != "" {
+		s += " lang:" + lang
+	}
+	return s
+}
+
+// selectAudioFormat picks the stream to download by the mirror audio format policy, it is reencoded to TgAudioBitrate anyway.
+func (m *Mirror) selectAudioFormat(vinfo *yt.Video) (audioFormat yt.Format, err error) {
+	p := m.AudioFormat
+
+	var audio, muxed []yt.Format
+	for _, f := range vinfo.Formats {
+		switch {
+		case strings.HasPrefix(f.MimeType, "audio/"):
+			audio = append(audio, f)
+		case strings.HasPrefix(f.MimeType, "video/") && f.AudioChannels > 0:
+			muxed = append(muxed, f)
+		}
+	}
+	formats := audio
+	if len(formats) == 0 {
+		if len(muxed) == 0 {
+			return audioFormat, fmt.Errorf("no audio formats in %d formats", len(vinfo.Formats))
+		}
+		log("No audio only formats, falling back to %d muxed formats", len(muxed))
+		formats = muxed
+	}
+
+	// the audio track in the preferred language, else the original one, else any
+	formats = narrowFormats(formats, func(f yt.Format) bool {
+		return p.Language != "" && strings.HasPrefix(formatTags(f)["lang"], p.Language)
+	})
+	formats = narrowFormats(formats, func(f yt.Format) bool {
+		acont := formatTags(f)["acont"]
+		return acont == "" || acont == "original"
+	})
+
+	switch p.Prefer {
+	case "mp4":
+		formats = narrowFormats(formats, func(f yt.Format) bool { return strings.Contains(f.MimeType, "/mp4") })
+	case "opus":
+		formats = narrowFormats(formats, func(f yt.Format) bool {
+			return strings.Contains(f.MimeType, "/webm") || strings.Contains(f.MimeType, "opus")
+		})
+	}
+
+	var target int
+	if p.Select == "closest" {
+		if target, err = parseBitrate(m.TgAudioBitrate); err != nil {
+			return audioFormat, err
+		}
+	}
+
+	for i, f := range formats {
+		if i == 0 {
+			audioFormat = f
+			continue
+		}
+		b, best := formatBitrate(f), formatBitrate(audioFormat)
+		switch p.Select {
+		case "lowest":
+			if b < best {
+				audioFormat = f
+			}
+		case "highest":
+			if b > best {
+				audioFormat = f
+			}
+		case "closest":
+			d, bestd := abs(b-target), abs(best-target)
+			if d < bestd || d == bestd && b > best {
+				audioFormat = f
+			}
+		}
+	}
+
+	return audioFormat, nil
+}
+
+// narrowFormats keeps the formats matching, unless none does.
+func narrowFormats(formats []yt.Format, match func(yt.Format) bool) []yt.Format {
+	var matching []yt.Format
+	for _, f := range formats {
+		if match(f) {
+			matching = append(matching, f)
+		}
+	}
+	if len(matching) == 0 {
+		return formats
+	}
+	return matching
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+var testAudioFormats = []yt.Format{
+	{ItagNo: 139, MimeType: `audio/mp4; codecs="mp4a.40.5"`, Bitrate: 50000, AverageBitrate: 48000, AudioChannels: 2},
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, Bitrate: 130000, AverageBitrate: 128000, AudioChannels: 2},
+	{ItagNo: 249, MimeType: `audio/webm; codecs="opus"`, Bitrate: 54000, AverageBitrate: 50000, AudioChannels: 2},
+	{ItagNo: 251, MimeType: `audio/webm; codecs="opus"`, Bitrate: 165000, AverageBitrate: 160000, AudioChannels: 2},
+	{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Bitrate: 500000, AudioChannels: 2, Height: 360},
+	{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, Bitrate: 1500000, Height: 720},
+}
+
+var testDubbedFormats = []yt.Format{
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AverageBitrate: 128000, URL: "https://example.com/videoplayback?itag=140&xtags=acont%3Ddubbed%3Alang%3Dde"},
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AverageBitrate: 129000, URL: "https://example.com/videoplayback?itag=140&xtags=acont%3Doriginal%3Alang%3Den-US"},
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AverageBitrate: 127000, Cipher: "s=abc&url=https%3A%2F%2Fexample.com%2Fvideoplayback%3Fitag%3D140%26xtags%3Dacont%253Ddubbed%253Alang%253Dfr"},
+}
+
+func TestSelectAudioFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		formats    []yt.Format
+		policy     AudioFormatPolicy
+		bitrate    string
+		itag       int
+		avgBitrate int
+		err        bool
+	}{
+		{name: "lowest mp4", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4"}, itag: 139},
+		{name: "highest mp4", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "highest", Prefer: "mp4"}, itag: 140},
+		{name: "lowest opus", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "opus"}, itag: 249},
+		{name: "highest any", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "highest", Prefer: "any"}, itag: 251},
+		{name: "closest mp4", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "closest", Prefer: "mp4"}, bitrate: "96k", itag: 140},
+		{name: "closest any", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "closest", Prefer: "any"}, bitrate: "60k", itag: 249},
+		{name: "closest bad bitrate", formats: testAudioFormats, policy: AudioFormatPolicy{Select: "closest", Prefer: "any"}, bitrate: "fast", err: true},
+		{name: "original track", formats: testDubbedFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4"}, itag: 140, avgBitrate: 129000},
+		{name: "language track", formats: testDubbedFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4", Language: "de"}, itag: 140, avgBitrate: 128000},
+		{name: "language track from the cipher", formats: testDubbedFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4", Language: "fr"}, itag: 140, avgBitrate: 127000},
+		{name: "missing language", formats: testDubbedFormats, policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4", Language: "ja"}, itag: 140, avgBitrate: 129000},
+		{name: "muxed fallback", formats: testAudioFormats[4:], policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4"}, itag: 18},
+		{name: "no audio", formats: testAudioFormats[5:], policy: AudioFormatPolicy{Select: "lowest", Prefer: "mp4"}, err: true},
+	} {
+		m := &Mirror{AudioFormat: tc.policy, TgAudioBitrate: tc.bitrate}
+		f, err := m.selectAudioFormat(&yt.Video{Formats: tc.formats})
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: got %s, want an error", tc.name, describeFormat(f))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if f.ItagNo != tc.itag || tc.avgBitrate != 0 && formatBitrate(f) != tc.avgBitrate {
+			t.Errorf("%s: got %s, want itag %d", tc.name, describeFormat(f), tc.itag)
+		}
+	}
+}
+
+func TestParseBitrate(t *testing.T) {
+	for s, want := range map[string]int{"96k": 96000, "1.5M": 1500000, "64000": 64000} {
+		if b, err := parseBitrate(s); err != nil || b != want {
+			t.Errorf("%s: got %d %v, want %d", s, b, err, want)
+		}
+	}
+	if _, err := parseBitrate("k"); err == nil {
+		t.Errorf("k: want an error")
+	}
+}
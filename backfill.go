@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ytVideos gets the videos by ids, 50 per request.
+func ytVideos(ids []string, part string) (videos []YtVideo, err error) {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > YtMaxResults {
+			n = YtMaxResults
+		}
+
+		VideoListUrlValues := url.Values{}
+		VideoListUrlValues.Set("part", part)
+		VideoListUrlValues.Set("id", strings.Join(ids[:n], ","))
+		var videoList YtVideoListResponse
+		err = ytGetJson("videos", VideoListUrlValues, &videoList)
+		if quotaStop(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get videos list: %v", err)
+		}
+		videos = append(videos, videoList.Items...)
+
+		ids = ids[n:]
+	}
+	return videos, nil
+}
+
+// backfill mirrors older videos independent of YtLast:
+// the given video ids or urls, or the videos of a playlist or of the mirror published in a date range.
+// The runs keep no entries of what they posted up to YtLast, so it is meant for the videos left out
+// by the first run policy or the filter, the backfilled ones are kept to be posted once.
+func backfill(m *Mirror, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	since := fs.String("since", "", "only videos published from this date, YYYY-MM-DD")
+	until := fs.String("until", "", "only videos published before this date, YYYY-MM-DD")
+	playlist := fs.String("playlist", "", "take the videos from this playlist instead of the mirror playlists")
+	limit := fs.Int("limit", 10, "post at most this many videos in this run, 0 for no limit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var sinceTime, untilTime time.Time
+	var err error
+	if *since != "" {
+		if sinceTime, err = time.Parse("2006-01-02", *since); err != nil {
+			return fmt.Errorf("backfill -since: %v", err)
+		}
+	}
+	if *until != "" {
+		if untilTime, err = time.Parse("2006-01-02", *until); err != nil {
+			return fmt.Errorf("backfill -until: %v", err)
+		}
+	}
+
+	Quota.Begin(m)
+	defer Quota.Summary()
+
+	// the ids are looked up and other playlists listed with the data api
+	if (fs.NArg() > 0 || *playlist != "") && YtKey == "" {
+		return fmt.Errorf("backfill: video ids and -playlist need YtKey")
+	}
+
+	var videos []YtPlaylistItemSnippet
+	if fs.NArg() > 0 {
+		var ids []string
+		for _, arg := range fs.Args() {
+			r, _, _, err := parseYtSource(arg)
+			if err == nil && r.VideoId == "" {
+				err = fmt.Errorf("not a video")
+			}
+			if err != nil {
+				return fmt.Errorf("backfill %s: %v", arg, err)
+			}
+			ids = append(ids, r.VideoId)
+		}
+		ytvideos, err := ytVideos(ids, YtVideoParts)
+		if err != nil {
+			return err
+		}
+		for _, v := range ytvideos {
+			vid, err := v.item()
+			if err != nil {
+				return fmt.Errorf("backfill: %v", err)
+			}
+			videos = append(videos, vid)
+		}
+		if len(videos) < len(ids) {
+			log("WARNING: backfill: %d of %d videos not found", len(ids)-len(videos), len(ids))
+		}
+	} else {
+		// older videos are wanted, so the uploads playlists are paged to the end
+		pm := *m
+		if *playlist != "" {
+			pm.YtPlaylistId = []string{*playlist}
+			pm.YtSources = nil
+			pm.YtSource = "api"
+		}
+		pm.YtFullScan = true
+		videos, err = pm.ListVideos()
+		if err != nil {
+			return err
+		}
+
+		// the date range is of the publishing time, the listing has when the videos were added to the playlist
+		if YtKey != "" && (*since != "" || *until != "") {
+			var indexes []int
+			for i, vid := range videos {
+				if vid.Details == nil {
+					indexes = append(indexes, i)
+				}
+			}
+			if err = setDetails(videos, indexes); err != nil {
+				return err
+			}
+		}
+	}
+
+	var queue []YtPlaylistItemSnippet
+	for _, vid := range videos {
+		publishedAt, err := time.Parse(time.RFC3339, vid.publishedAt())
+		if err != nil {
+			return fmt.Errorf("backfill %s: publishedAt: %v", vid.ResourceId.VideoId, err)
+		}
+		if !sinceTime.IsZero() && publishedAt.Before(sinceTime) {
+			continue
+		}
+		if !untilTime.IsZero() && !publishedAt.Before(untilTime) {
+			continue
+		}
+		queue = append(queue, vid)
+	}
+
+	log("Backfill: %d videos", len(queue))
+
+	return m.withLock(func() error {
+		var processed int
+		for vidnum, vid := range queue {
+			if stopping() {
+				break
+			}
+			if *limit > 0 && processed >= *limit {
+				log("Backfill limit %d reached, run again to continue", *limit)
+				break
+			}
+
+			// the videos up to YtLast are done for a run, here only the ones posted with an entry are
+			entry, err := m.LedgerGet(vid.ResourceId.VideoId)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				entry = newLedgerEntry(vid)
+			}
+			if entry.Status == VideoPosted {
+				continue
+			}
+			entry.Status = VideoPending
+			entry.Reason = "backfill"
+			entry.Backfill = true
+
+			err = m.processVideo(vidnum, vid, entry)
+			if err != nil {
+				if Ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+			processed++
+		}
+		return nil
+	})
+}
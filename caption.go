@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultCaptionTemplate is the photo caption when the mirror has no TgCaptionTemplate.
+const DefaultCaptionTemplate = `<u><b>{{.CleanTitle}}</b></u>`
+
+var TgCaptionTemplate string
+
+// CaptionData is what the photo caption template can use, the details are empty without YtKey.
+type CaptionData struct {
+	VideoId     string
+	Url         string
+	Title       string
+	CleanTitle  string
+	Description string
+	PublishedAt string
+	Playlists   []string
+
+	Duration             time.Duration
+	Tags                 []string
+	CategoryId           string
+	PrivacyStatus        string
+	DefaultLanguage      string
+	DefaultAudioLanguage string
+	LiveBroadcastContent string
+}
+
+var captionFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func parseCaptionTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultCaptionTemplate
+	}
+	t, err := template.New("caption").Funcs(captionFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("TgCaptionTemplate: %v", err)
+	}
+	return t, nil
+}
+
+func (m *Mirror) photoCaption(vid YtPlaylistItemSnippet, title string) (string, error) {
+	data := CaptionData{
+		VideoId:     vid.ResourceId.VideoId,
+		Url:         "https://youtu.be/" + vid.ResourceId.VideoId,
+		Title:       vid.Title,
+		CleanTitle:  title,
+		Description: vid.Description,
+		PublishedAt: vid.publishedAt(),
+		Playlists:   vid.Playlists,
+	}
+	if d := vid.Details; d != nil {
+		data.Duration = d.Duration
+		data.Tags = d.Tags
+		data.CategoryId = d.CategoryId
+		data.PrivacyStatus = d.PrivacyStatus
+		data.DefaultLanguage = d.DefaultLanguage
+		data.DefaultAudioLanguage = d.DefaultAudioLanguage
+		data.LiveBroadcastContent = d.LiveBroadcastContent
+	}
+
+	var buf bytes.Buffer
+	if err := m.captionTemplate.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("caption template: %v", err)
+	}
+	return buf.String(), nil
+}
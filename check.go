@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type TgUser struct {
+	Id       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username"`
+}
+
+type TgChat struct {
+	Id       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Username string `json:"username"`
+}
+
+type TgChatMember struct {
+	Status             string `json:"status"`
+	CanPostMessages    bool   `json:"can_post_messages"`
+	CanDeleteMessages  bool   `json:"can_delete_messages"`
+	CanEditMessages    bool   `json:"can_edit_messages"`
+	CanManageChat      bool   `json:"can_manage_chat"`
+	CanRestrictMembers bool   `json:"can_restrict_members"`
+}
+
+// tgcall posts a bot api method and decodes its result.
+func tgcall(method string, params map[string]interface{}, result interface{}) error {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	var tgresp struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	err = postJson(
+		fmt.Sprintf("https://api.telegram.org/bot%s/%s", TgToken, method),
+		bytes.NewBuffer(paramsJSON),
+		&tgresp,
+	)
+	if err != nil {
+		return err
+	}
+	if !tgresp.Ok {
+		return fmt.Errorf("%s: %s", method, tgresp.Description)
+	}
+
+	return json.Unmarshal(tgresp.Result, result)
+}
+
+type checkReport struct {
+	failed int
+}
+
+func (r *checkReport) pass(name, msg string, args ...interface{}) {
+	fmt.Printf("PASS %s: %s\n", name, fmt.Sprintf(msg, args...))
+}
+
+func (r *checkReport) fail(name, msg string, args ...interface{}) {
+	r.failed++
+	fmt.Printf("FAIL %s: %s\n", name, fmt.Sprintf(msg, args...))
+}
+
+// check validates the credentials and the environment of the mirrors without posting anything.
+func check(mirrors []*Mirror) error {
+	var r checkReport
+
+	var me TgUser
+	if err := tgcall("getMe", nil, &me); err != nil {
+		r.fail("telegram getMe", "%v; check TgToken, it is given by @BotFather", err)
+	} else {
+		r.pass("telegram getMe", "@%s id:%d", me.Username, me.Id)
+	}
+
+	chats := make(map[string]bool)
+	for _, m := range mirrors {
+		if chats[m.TgChatId] || me.Id == 0 {
+			continue
+		}
+		chats[m.TgChatId] = true
+		checkTgChat(&r, m.TgChatId, me.Id)
+	}
+
+	for _, m := range mirrors {
+		Quota.Begin(m)
+		checkYt(&r, m)
+	}
+
+	if out, err := exec.Command(FfmpegPath, "-version").Output(); err != nil {
+		r.fail("ffmpeg", "%s -version: %v; set FfmpegPath to the ffmpeg executable", FfmpegPath, err)
+	} else {
+		r.pass("ffmpeg", "%s", strings.SplitN(string(out), "\n", 2)[0])
+	}
+
+	checkYtDlp(&r, mirrors)
+
+	checkKey := "YtCheck"
+	checkValue := time.Now().UTC().Format(time.RFC3339)
+	if err := State.Set(checkKey, checkValue); err != nil {
+		r.fail("state store", "Set: %v; check StateStore and StatePath permissions", err)
+	} else if v, err := State.Get(checkKey); err != nil {
+		r.fail("state store", "Get: %v", err)
+	} else if v != checkValue {
+		r.fail("state store", "read back %#v instead of %#v", v, checkValue)
+	} else if err := State.Delete(checkKey); err != nil {
+		r.fail("state store", "Delete: %v", err)
+	} else {
+		r.pass("state store", "%T is writable", State)
+	}
+
+	if r.failed > 0 {
+		return fmt.Errorf("check: %d failed", r.failed)
+	}
+	return nil
+}
+
+func checkTgChat(r *checkReport, chatid string, botid int64) {
+	name := fmt.Sprintf("telegram chat %s", chatid)
+
+	var chat TgChat
+	if err := tgcall("getChat", map[string]interface{}{"chat_id": chatid}, &chat); err != nil {
+		r.fail(name, "getChat: %v; check TgChatId and that the bot is added to the chat", err)
+		return
+	}
+
+	var member TgChatMember
+	err := tgcall("getChatMember", map[string]interface{}{"chat_id": chatid, "user_id": botid}, &member)
+	if err != nil {
+		r.fail(name, "getChatMember: %v", err)
+		return
+	}
+
+	switch {
+	case member.Status == "creator":
+	case member.Status != "administrator":
+		r.fail(name, "bot is %s, make it an administrator of the %s", member.Status, chat.Type)
+		return
+	case chat.Type == "channel" && !member.CanPostMessages:
+		r.fail(name, "bot can not post messages, allow it in the administrator rights")
+		return
+	case !member.CanDeleteMessages:
+		r.fail(name, "bot can not delete messages, allow it in the administrator rights")
+		return
+	}
+
+	r.pass(name, "%s %#v, bot is %s", chat.Type, chat.Title, member.Status)
+}
+
+func checkYt(r *checkReport, m *Mirror) {
+	name := "youtube"
+	if m.Name != "" {
+		name = fmt.Sprintf("youtube %s", m.Name)
+	}
+
+	if m.YtSource == "feed" {
+		videos, err := m.listFeed()
+		if err != nil {
+			r.fail(name, "%v; check YtFeedBaseUrl and YtChannelId or YtPlaylistId", err)
+			return
+		}
+		r.pass(name, "feed has %d videos", len(videos))
+		return
+	}
+
+	playlistIds, err := m.PlaylistIds()
+	if err != nil {
+		r.fail(name, "%v; check YtKey and YtUsername, YtChannelId or YtPlaylistId", err)
+		return
+	}
+
+	PlaylistsUrlValues := url.Values{}
+	PlaylistsUrlValues.Set("part", "snippet")
+	PlaylistsUrlValues.Set("id", strings.Join(playlistIds, ","))
+	var playlists struct {
+		Items []struct {
+			Id      string `json:"id"`
+			Snippet struct {
+				Title string `json:"title"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}
+	err = ytGetJson("playlists", PlaylistsUrlValues, &playlists)
+	if err != nil {
+		r.fail(name, "playlists: %v; check YtKey", err)
+		return
+	}
+
+	found := make(map[string]string)
+	for _, p := range playlists.Items {
+		found[p.Id] = p.Snippet.Title
+	}
+	for _, plid := range playlistIds {
+		title, ok := found[plid]
+		if !ok {
+			r.fail(name, "playlist %s not found or private", plid)
+			continue
+		}
+		r.pass(name, "playlist %s %#v", plid, title)
+	}
+}
+
+// checkYtDlp fails only when a mirror prefers yt-dlp, as a fallback it is optional.
+func checkYtDlp(r *checkReport, mirrors []*Mirror) {
+	var used, preferred bool
+	for _, m := range mirrors {
+		for i, d := range m.downloaders {
+			if d.Name() == "yt-dlp" {
+				used = true
+				preferred = preferred || i == 0
+			}
+		}
+	}
+	if !used {
+		return
+	}
+
+	out, err := exec.Command(YtDlpPath, "--version").Output()
+	switch {
+	case err != nil && preferred:
+		r.fail("yt-dlp", "%s --version: %v; set YtDlpPath to the yt-dlp executable", YtDlpPath, err)
+	case err != nil:
+		r.pass("yt-dlp", "%s --version: %v; the fallback downloader is not available", YtDlpPath, err)
+	default:
+		r.pass("yt-dlp", "%s", strings.TrimSpace(string(out)))
+	}
+}
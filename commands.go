@@ -0,0 +1,378 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+var (
+	FlagDryRun   = flag.Bool("dry-run", false, "print what would be posted without downloading or sending anything")
+	FlagJson     = flag.Bool("json", false, "print the dry run plan as json")
+	FlagMirror   = flag.String("mirror", "", "operate only on the mirror with this name")
+	FlagFullScan = flag.Bool("full-scan", false, "list every page of the uploads playlists instead of stopping at known videos")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage: yttgchan [flags] [command] [args]
+
+commands:
+  run                   post every video not posted yet (default)
+  daemon                run every mirror on its interval until a signal is received
+  status                show the state and the pending count
+  list                  list the source videos with posted/pending markers
+  resend <videoId>      post the video again
+  skip <videoId>        mark the video as skipped
+  set-last <audioName>  mark videos up to audioName as done and the later ones as pending
+  reset                 delete the whole state of the mirror
+  check                 validate the credentials and the environment
+  backfill [-since YYYY-MM-DD] [-until YYYY-MM-DD] [-playlist id] [-limit N] [videoId|url ...]
+                        mirror older videos independent of YtLast
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+// command runs the command named by the first argument on the selected mirrors.
+func command(args []string) error {
+	var name string
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	mirrors := Mirrors
+	if *FlagMirror != "" {
+		mirrors = nil
+		for _, m := range Mirrors {
+			if m.Name == *FlagMirror {
+				mirrors = append(mirrors, m)
+			}
+		}
+		if len(mirrors) == 0 {
+			return fmt.Errorf("no mirror named %s", *FlagMirror)
+		}
+	}
+
+	switch name {
+	case "", "run":
+		if *FlagDryRun {
+			return dryRun(mirrors)
+		}
+		handleSignals()
+		return run(mirrors)
+	case "daemon":
+		handleSignals()
+		daemon(mirrors)
+		return nil
+	case "status":
+		return status(mirrors)
+	case "list":
+		return list(mirrors)
+	case "reset":
+		return reset(mirrors)
+	case "check":
+		return check(mirrors)
+	}
+
+	if name == "backfill" {
+		if len(mirrors) != 1 {
+			return fmt.Errorf("%s: select one mirror with -mirror", name)
+		}
+		handleSignals()
+		return backfill(mirrors[0], args)
+	}
+
+	if len(args) != 1 {
+		flag.Usage()
+		return fmt.Errorf("%s: exactly one argument expected", name)
+	}
+	if len(mirrors) != 1 {
+		return fmt.Errorf("%s: select one mirror with -mirror", name)
+	}
+	m := mirrors[0]
+
+	switch name {
+	case "resend":
+		handleSignals()
+		return m.Resend(args[0])
+	case "skip":
+		return m.Skip(args[0], "skipped by command")
+	case "set-last":
+		return m.SetLast(args[0])
+	}
+
+	flag.Usage()
+	return fmt.Errorf("unknown command %s", name)
+}
+
+func run(mirrors []*Mirror) (err error) {
+	for _, m := range mirrors {
+		if stopping() {
+			break
+		}
+		if m.Name != "" {
+			log("Mirror: %s", m.Name)
+		}
+		if merr := m.Run(); merr != nil {
+			log("ERROR: %v", merr)
+			err = fmt.Errorf("some mirrors failed")
+		}
+	}
+	return err
+}
+
+func dryRun(mirrors []*Mirror) error {
+	var plans []*Plan
+	for _, m := range mirrors {
+		Quota.Begin(m)
+		plan, err := m.Plan()
+		if err != nil {
+			return fmt.Errorf("mirror %s: %v", m.Name, err)
+		}
+		plans = append(plans, plan)
+	}
+	return printPlans(plans, *FlagJson)
+}
+
+func status(mirrors []*Mirror) error {
+	for _, m := range mirrors {
+		Quota.Begin(m)
+		entries, err := m.LedgerList()
+		if err != nil {
+			return err
+		}
+		counts := make(map[string]int)
+		for _, e := range entries {
+			counts[e.Status]++
+		}
+
+		videos, err := m.ListVideos()
+		if err != nil {
+			return err
+		}
+		var pending int
+		for _, vid := range videos {
+			e, err := m.ledgerEntry(vid)
+			if err != nil {
+				return err
+			}
+			if e.Due() {
+				pending++
+			}
+		}
+
+		if m.Name != "" {
+			fmt.Printf("Mirror: %s\n", m.Name)
+		}
+		fmt.Printf("Chat: %s\n", m.TgChatId)
+		fmt.Printf("YtLast: %s\n", m.Last)
+		fmt.Printf("Videos: %d\n", len(videos))
+		fmt.Printf("Pending: %d\n", pending)
+		units, err := Quota.Today()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Quota today: %d units by this mirror, %d in total, day budget %d\n", Quota.day.Mirrors[m.Name], units, YtQuotaDayBudget)
+		fmt.Printf(
+			"Ledger: %d posted, %d pending, %d failed, %d skipped\n",
+			counts[VideoPosted], counts[VideoPending], counts[VideoFailed], counts[VideoSkipped],
+		)
+		for _, e := range entries {
+			if e.Status == VideoFailed || e.Status == VideoSkipped || e.Status == VideoPending && e.Reason != "" {
+				fmt.Printf("  %s %s %s: %s\n", e.Status, e.VideoId, e.AudioName, e.Reason)
+			}
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func statusMarker(e *LedgerEntry) string {
+	switch e.Status {
+	case VideoPosted:
+		return "+"
+	case VideoFailed:
+		if !e.Due() {
+			return "x"
+		}
+		return "!"
+	case VideoSkipped:
+		return "-"
+	}
+	return " "
+}
+
+func list(mirrors []*Mirror) error {
+	for _, m := range mirrors {
+		Quota.Begin(m)
+		videos, err := m.ListVideos()
+		if err != nil {
+			return err
+		}
+		if m.Name != "" {
+			fmt.Printf("Mirror: %s\n", m.Name)
+		}
+		for vidnum, vid := range videos {
+			e, err := m.ledgerEntry(vid)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s #%d %s %s\n", statusMarker(e), vidnum+1, e.AudioName, m.cleanTitle(vid.Title))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func reset(mirrors []*Mirror) error {
+	for _, m := range mirrors {
+		if err := m.withLock(m.reset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reset deletes the state of the mirror, holding its lock so a running run does not write it again.
+func (m *Mirror) reset() error {
+	keys, err := State.Keys(m.key(LedgerPrefix))
+	if err != nil {
+		return err
+	}
+	keys = append(keys, m.key(LedgerKey), m.key("YtLast"), m.key("YtResolved"))
+	for _, k := range keys {
+		if err := State.Delete(k); err != nil {
+			return fmt.Errorf("Delete %s: %v", k, err)
+		}
+	}
+	m.Last = ""
+	m.resolved = nil
+	m.resetLedger()
+	log("Reset %s: %d keys deleted", m.Name, len(keys))
+	return nil
+}
+
+// findVideo looks the video up in the mirror playlists, paged to the end as it may be older than the first pages.
+func (m *Mirror) findVideo(ytid string) (int, YtPlaylistItemSnippet, error) {
+	fm := *m
+	fm.YtFullScan = true
+	videos, err := fm.ListVideos()
+	if err != nil {
+		return 0, YtPlaylistItemSnippet{}, err
+	}
+	for vidnum, vid := range videos {
+		if vid.ResourceId.VideoId == ytid {
+			return vidnum, vid, nil
+		}
+	}
+	return 0, YtPlaylistItemSnippet{}, fmt.Errorf("video %s is not in the mirror playlists", ytid)
+}
+
+// Resend posts the video again regardless of its ledger status.
+func (m *Mirror) Resend(ytid string) error {
+	return m.withLock(func() error { return m.resend(ytid) })
+}
+
+func (m *Mirror) resend(ytid string) error {
+	vidnum, vid, err := m.findVideo(ytid)
+	if err != nil {
+		return err
+	}
+	entry, err := m.ledgerEntry(vid)
+	if err != nil {
+		return err
+	}
+	entry.Status = VideoPending
+	entry.Reason = ""
+	entry.Attempts = 0
+	entry.TgPhotoMessageId, entry.TgAudioMessageId, entry.TgVideoMessageId, entry.TgMessageMessageId = 0, 0, 0, 0
+
+	if err = m.processVideo(vidnum, vid, entry); err != nil {
+		return err
+	}
+	if entry.Status != VideoPosted {
+		return fmt.Errorf("resend %s: %s", ytid, entry.Reason)
+	}
+	return nil
+}
+
+// Skip marks the video as skipped, holding the mirror lock so a running run does not overwrite it.
+func (m *Mirror) Skip(ytid, reason string) error {
+	return m.withLock(func() error { return m.skip(ytid, reason) })
+}
+
+func (m *Mirror) skip(ytid, reason string) error {
+	entry, err := m.LedgerGet(ytid)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		_, vid, err := m.findVideo(ytid)
+		if err != nil {
+			return err
+		}
+		if entry, err = m.ledgerEntry(vid); err != nil {
+			return err
+		}
+	}
+	entry.Status = VideoSkipped
+	entry.Reason = reason
+	if err = m.LedgerPut(entry); err != nil {
+		return err
+	}
+	log("Skipped %s %s", entry.VideoId, entry.AudioName)
+	return nil
+}
+
+// SetLast moves the watermark: the ledger entries up to audioName are marked as skipped unless posted,
+// and later ones are made pending again, like editing YtLast did before the ledger.
+func (m *Mirror) SetLast(audioName string) error {
+	return m.withLock(func() error { return m.setLast(audioName) })
+}
+
+func (m *Mirror) setLast(audioName string) error {
+	if i := strings.LastIndex(audioName, "."); i < 0 || i == len(audioName)-1 {
+		return fmt.Errorf("set-last: %s does not look like an audio name, expected publishedAt.videoId", audioName)
+	}
+
+	entries, err := m.LedgerList()
+	if err != nil {
+		return err
+	}
+
+	var done, pending int
+	var changed []*LedgerEntry
+	for _, entry := range entries {
+		if entry.AudioName <= audioName {
+			if entry.Status == VideoPosted || entry.Status == VideoSkipped {
+				continue
+			}
+			entry.Status = VideoSkipped
+			entry.Reason = fmt.Sprintf("set-last %s", audioName)
+			done++
+		} else {
+			if entry.Status == VideoPending && entry.Attempts == 0 {
+				continue
+			}
+			entry.Status = VideoPending
+			entry.Reason = fmt.Sprintf("set-last %s", audioName)
+			entry.Attempts = 0
+			entry.LastAttempt = nil
+			pending++
+		}
+		changed = append(changed, entry)
+	}
+
+	// the videos without entries follow the watermark, and the entries up to it are pruned on the write
+	m.Last = audioName
+	if err = State.Set(m.key("YtLast"), audioName); err != nil {
+		return err
+	}
+	if err = m.LedgerPutAll(changed); err != nil {
+		return err
+	}
+
+	log("YtLast set to %s: %d entries marked done, %d pending", audioName, done, pending)
+	return nil
+}
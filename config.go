@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mirror is one youtube source to telegram chat pair with its own progress state.
+type Mirror struct {
+	Name string `yaml:"name"`
+
+	YtUsername   string   `yaml:"ytUsername"`
+	YtChannelId  string   `yaml:"ytChannelId"`
+	YtPlaylistId []string `yaml:"ytPlaylistId"`
+
+	// YtSources are channel urls, @handles, custom urls, playlist urls or video urls
+	YtSources []string `yaml:"ytSources"`
+
+	// YtFullScan lists every page of the uploads playlists instead of stopping at known videos
+	YtFullScan bool `yaml:"ytFullScan"`
+
+	// YtQuotaRunBudget is the data api units a run of the mirror may use, 0 for no limit
+	YtQuotaRunBudget int `yaml:"ytQuotaRunBudget"`
+
+	// YtSource is api for the data api or feed for the public feeds which need no api key
+	YtSource string `yaml:"ytSource"`
+
+	TgChatId       string `yaml:"tgChatId"`
+	TgPerformer    string `yaml:"tgPerformer"`
+	TgAudioBitrate string `yaml:"tgAudioBitrate"`
+	TgTitleCleanRe string `yaml:"tgTitleCleanRe"`
+	TgTitleUnquote bool   `yaml:"tgTitleUnquote"`
+
+	// TgMode is audio or video, the video is transcoded to fit TgVideoMaxMb and scaled to TgVideoMaxHeight
+	TgMode           string `yaml:"tgMode"`
+	TgVideoMaxMb     int    `yaml:"tgVideoMaxMb"`
+	TgVideoMaxHeight int    `yaml:"tgVideoMaxHeight"`
+
+	// TgCaptionTemplate is the text/template of the photo caption with CaptionData, see DefaultCaptionTemplate
+	TgCaptionTemplate string `yaml:"tgCaptionTemplate"`
+
+	Interval time.Duration `yaml:"interval"`
+
+	// YtFirstRun is the first run policy, see FirstRunPolicy
+	YtFirstRun string `yaml:"ytFirstRun"`
+
+	Filter *VideoFilter `yaml:"filter"`
+
+	AudioFormat AudioFormatPolicy `yaml:"audioFormat"`
+
+	// YtDownloaders are the downloaders in the order they are tried, see Downloaders
+	YtDownloaders string `yaml:"ytDownloaders"`
+
+	// YtUnavailablePolicy overrides DefaultUnavailablePolicy, like "private=skip"
+	YtUnavailablePolicy string `yaml:"ytUnavailablePolicy"`
+
+	Last string `yaml:"-"`
+
+	titleCleanRe      *regexp.Regexp
+	captionTemplate   *template.Template
+	firstRunPolicy    FirstRunPolicy
+	unavailablePolicy map[string]string
+	ledger            Ledger
+	downloaders       []Downloader
+	resolved          []YtResolved
+}
+
+type Config struct {
+	Mirrors []*Mirror `yaml:"mirrors"`
+}
+
+// UnmarshalYAML starts the bool fields from the environment values, as an unset bool can not be told from false,
+// so a mirror can turn off what the environment turns on.
+func (m *Mirror) UnmarshalYAML(value *yaml.Node) error {
+	type mirror Mirror
+	mm := mirror{
+		TgTitleUnquote: TgTitleUnquote,
+		YtFullScan:     YtFullScan,
+	}
+	if err := value.Decode(&mm); err != nil {
+		return err
+	}
+	*m = Mirror(mm)
+	return nil
+}
+
+var (
+	ConfigPath string
+	Mirrors    []*Mirror
+)
+
+// LoadConfig reads the mirrors list from a yaml file,
+// empty mirror fields default to the values from the environment.
+func LoadConfig(path string) ([]*Mirror, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err = yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("yaml.Unmarshal %s: %v", path, err)
+	}
+	if len(config.Mirrors) == 0 {
+		return nil, fmt.Errorf("%s: no mirrors", path)
+	}
+
+	names := make(map[string]bool)
+	for i, m := range config.Mirrors {
+		if m.Name == "" {
+			return nil, fmt.Errorf("%s: mirror #%d: name empty", path, i+1)
+		}
+		if strings.ContainsAny(m.Name, ". =") {
+			return nil, fmt.Errorf("%s: mirror %s: name must not contain dots, spaces or equal signs", path, m.Name)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("%s: mirror %s: duplicate name", path, m.Name)
+		}
+		names[m.Name] = true
+
+		if m.TgChatId == "" {
+			m.TgChatId = TgChatId
+		}
+		if m.TgChatId == "" {
+			return nil, fmt.Errorf("%s: mirror %s: tgChatId empty", path, m.Name)
+		}
+		if m.TgPerformer == "" {
+			m.TgPerformer = TgPerformer
+		}
+		if m.TgAudioBitrate == "" {
+			m.TgAudioBitrate = TgAudioBitrate
+		}
+		if m.TgTitleCleanRe == "" {
+			m.TgTitleCleanRe = TgTitleCleanRe
+		}
+		if m.TgCaptionTemplate == "" {
+			m.TgCaptionTemplate = TgCaptionTemplate
+		}
+		if m.YtFirstRun == "" {
+			m.YtFirstRun = YtFirstRun
+		}
+		if m.YtSource == "" {
+			m.YtSource = YtSource
+		}
+		if m.YtQuotaRunBudget == 0 {
+			m.YtQuotaRunBudget = YtQuotaRunBudget
+		}
+		if m.AudioFormat.Select == "" {
+			m.AudioFormat.Select = YtAudioSelect
+		}
+		if m.AudioFormat.Prefer == "" {
+			m.AudioFormat.Prefer = YtAudioPrefer
+		}
+		if m.AudioFormat.Language == "" {
+			m.AudioFormat.Language = YtAudioLanguage
+		}
+		if m.TgMode == "" {
+			m.TgMode = TgMode
+		}
+		if m.TgVideoMaxMb == 0 {
+			m.TgVideoMaxMb = TgVideoMaxMb
+		}
+		if m.TgVideoMaxHeight == 0 {
+			m.TgVideoMaxHeight = TgVideoMaxHeight
+		}
+		if m.YtDownloaders == "" {
+			m.YtDownloaders = YtDownloaders
+		}
+		if m.YtUnavailablePolicy == "" {
+			m.YtUnavailablePolicy = YtUnavailablePolicy
+		}
+		if m.Filter == nil {
+			m.Filter, err = envFilter()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return config.Mirrors, nil
+}
+
+// EnvMirror is the single unnamed mirror configured by environment variables,
+// its state keys have no prefix to stay compatible with existing state.
+func EnvMirror() (*Mirror, error) {
+	if TgChatId == "" {
+		return nil, fmt.Errorf("TgChatId empty")
+	}
+	filter, err := envFilter()
+	if err != nil {
+		return nil, err
+	}
+	return &Mirror{
+		YtUsername:          YtUsername,
+		YtChannelId:         YtChannelId,
+		YtPlaylistId:        strings.Fields(YtPlaylistId),
+		YtSources:           strings.Fields(YtSources),
+		YtSource:            YtSource,
+		YtFullScan:          YtFullScan,
+		YtQuotaRunBudget:    YtQuotaRunBudget,
+		TgChatId:            TgChatId,
+		TgPerformer:         TgPerformer,
+		TgAudioBitrate:      TgAudioBitrate,
+		TgTitleCleanRe:      TgTitleCleanRe,
+		TgTitleUnquote:      TgTitleUnquote,
+		TgCaptionTemplate:   TgCaptionTemplate,
+		YtFirstRun:          YtFirstRun,
+		Filter:              filter,
+		AudioFormat:         AudioFormatPolicy{Select: YtAudioSelect, Prefer: YtAudioPrefer, Language: YtAudioLanguage},
+		TgMode:              TgMode,
+		TgVideoMaxMb:        TgVideoMaxMb,
+		TgVideoMaxHeight:    TgVideoMaxHeight,
+		YtDownloaders:       YtDownloaders,
+		YtUnavailablePolicy: YtUnavailablePolicy,
+		Last:                os.Getenv("YtLast"),
+	}, nil
+}
+
+// Init compiles the title regexp and reads the mirror watermark from the state store.
+func (m *Mirror) Init() error {
+	var err error
+	if m.TgTitleCleanRe != "" {
+		m.titleCleanRe, err = regexp.Compile(m.TgTitleCleanRe)
+		if err != nil {
+			return fmt.Errorf("TgTitleCleanRe: %v", err)
+		}
+	}
+
+	m.captionTemplate, err = parseCaptionTemplate(m.TgCaptionTemplate)
+	if err != nil {
+		return err
+	}
+
+	switch m.YtSource {
+	case "", "api":
+		m.YtSource = "api"
+		if YtKey == "" {
+			return fmt.Errorf("YtKey empty, it is required by the api source")
+		}
+	case "feed":
+	default:
+		return fmt.Errorf("unknown source %#v, expected api or feed", m.YtSource)
+	}
+
+	m.firstRunPolicy, err = ParseFirstRunPolicy(m.YtFirstRun)
+	if err != nil {
+		return err
+	}
+
+	if err = m.AudioFormat.check(); err != nil {
+		return err
+	}
+
+	switch m.TgMode {
+	case "", TgModeAudio:
+		m.TgMode = TgModeAudio
+	case TgModeVideo:
+		if m.TgVideoMaxMb <= 0 || m.TgVideoMaxHeight <= 0 {
+			return fmt.Errorf("TgVideoMaxMb and TgVideoMaxHeight should be positive")
+		}
+	default:
+		return fmt.Errorf("unknown mode %#v, expected audio or video", m.TgMode)
+	}
+
+	m.downloaders, err = parseDownloaders(m.YtDownloaders)
+	if err != nil {
+		return err
+	}
+
+	m.unavailablePolicy, err = parseUnavailablePolicy(m.YtUnavailablePolicy)
+	if err != nil {
+		return err
+	}
+
+	if m.Filter != nil {
+		if err = m.Filter.compile(); err != nil {
+			return err
+		}
+		if m.Filter.needsDetails() && YtKey == "" {
+			return fmt.Errorf("filter: the duration and type rules need YtKey")
+		}
+	}
+
+	last, err := State.Get(m.key("YtLast"))
+	if err != nil {
+		return fmt.Errorf("state store get YtLast: %v", err)
+	}
+	if last != "" {
+		m.Last = last
+	}
+
+	return nil
+}
+
+func (m *Mirror) key(name string) string {
+	if m.Name == "" {
+		return name
+	}
+	return m.Name + "." + name
+}
+
+// envFilter parses YtFilter, the filter of the environment mirror and the default of the configured ones.
+func envFilter() (*VideoFilter, error) {
+	if YtFilter == "" {
+		return nil, nil
+	}
+	var f VideoFilter
+	if err := yaml.Unmarshal([]byte(YtFilter), &f); err != nil {
+		return nil, fmt.Errorf("YtFilter: %v", err)
+	}
+	return &f, nil
+}
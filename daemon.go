@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	// StopCtx is cancelled on the first signal: the current video is finished and no new one is started.
+	// Ctx is cancelled on the second signal: the current video is aborted before anything is posted.
+	StopCtx = context.Background()
+
+	DaemonInterval = time.Hour
+	DaemonJitter   = 5 * time.Minute
+)
+
+// handleSignals is installed by the commands which post, the others keep the default handling and exit on the first signal.
+func handleSignals() {
+	var stop, abort context.CancelFunc
+	StopCtx, stop = context.WithCancel(context.Background())
+	Ctx, abort = context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log("Signal %v: finishing the current video, send again to abort it", sig)
+		stop()
+		sig = <-sigs
+		log("Signal %v: aborting the current video, send again to exit", sig)
+		abort()
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+	}()
+}
+
+func stopping() bool {
+	return StopCtx.Err() != nil
+}
+
+func (m *Mirror) interval() time.Duration {
+	if m.Interval > 0 {
+		return m.Interval
+	}
+	return DaemonInterval
+}
+
+func jitter(d time.Duration) time.Duration {
+	if DaemonJitter <= 0 {
+		return d
+	}
+	d += time.Duration(rand.Int63n(int64(2*DaemonJitter))) - DaemonJitter
+	if d < time.Minute {
+		d = time.Minute
+	}
+	return d
+}
+
+// daemon runs every mirror on its interval until a signal is received.
+func daemon(mirrors []*Mirror) {
+	rand.Seed(time.Now().UnixNano())
+
+	next := make([]time.Time, len(mirrors))
+	for {
+		for i, m := range mirrors {
+			if stopping() {
+				return
+			}
+			if time.Now().Before(next[i]) {
+				continue
+			}
+			if m.Name != "" {
+				log("Mirror: %s", m.Name)
+			}
+			if err := m.Run(); err != nil {
+				log("ERROR: %v", err)
+			}
+			next[i] = time.Now().Add(jitter(m.interval()))
+		}
+
+		wake := next[0]
+		for _, t := range next {
+			if t.Before(wake) {
+				wake = t
+			}
+		}
+		log("Sleeping until %s", wake.Format(time.RFC3339))
+
+		select {
+		case <-StopCtx.Done():
+			return
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
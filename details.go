@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// YtVideoParts are the parts of the videos list the details are made of, any parts cost one unit per call.
+const YtVideoParts = "contentDetails,snippet,liveStreamingDetails,status"
+
+// YtVideoDetails are the video fields the playlist items do not have.
+type YtVideoDetails struct {
+	// PublishedAt is when the video was published, not when it was added to the playlist
+	PublishedAt string
+	Duration    time.Duration
+
+	Tags                 []string
+	CategoryId           string
+	PrivacyStatus        string
+	UploadStatus         string
+	AgeRestricted        bool
+	DefaultLanguage      string
+	DefaultAudioLanguage string
+
+	// LiveBroadcastContent is none, upcoming or live
+	LiveBroadcastContent string
+	LiveStreaming        *YtLiveStreamingDetails
+
+	// Missing is set when the videos list does not have the video, it is private or deleted then
+	Missing bool
+}
+
+func (v *YtVideo) details() (*YtVideoDetails, error) {
+	duration, err := parseIsoDuration(v.ContentDetails.Duration)
+	if err != nil {
+		return nil, fmt.Errorf("video %s: %v", v.Id, err)
+	}
+	return &YtVideoDetails{
+		PublishedAt:          v.Snippet.PublishedAt,
+		Duration:             duration,
+		Tags:                 v.Snippet.Tags,
+		CategoryId:           v.Snippet.CategoryId,
+		PrivacyStatus:        v.Status.PrivacyStatus,
+		UploadStatus:         v.Status.UploadStatus,
+		AgeRestricted:        v.ContentDetails.ContentRating.YtRating == "ytAgeRestricted",
+		DefaultLanguage:      v.Snippet.DefaultLanguage,
+		DefaultAudioLanguage: v.Snippet.DefaultAudioLanguage,
+		LiveBroadcastContent: v.Snippet.LiveBroadcastContent,
+		LiveStreaming:        v.LiveStreamingDetails,
+	}, nil
+}
+
+// item makes a playlist item of the video with its details.
+func (v *YtVideo) item() (vid YtPlaylistItemSnippet, err error) {
+	vid = v.Snippet.YtPlaylistItemSnippet
+	vid.ResourceId.VideoId = v.Id
+	vid.Details, err = v.details()
+	return vid, err
+}
+
+// enrichVideos sets the details of the due videos which have none yet. Without YtKey the videos are left as they are.
+func (m *Mirror) enrichVideos(videos []YtPlaylistItemSnippet) error {
+	if YtKey == "" {
+		return nil
+	}
+
+	var due []int
+	for i, vid := range videos {
+		if vid.Details != nil {
+			continue
+		}
+		entry, err := m.ledgerEntry(vid)
+		if err != nil {
+			return err
+		}
+		if !entry.Due() {
+			continue
+		}
+		due = append(due, i)
+	}
+	return setDetails(videos, due)
+}
+
+// setDetails sets the details of the videos at the indexes from the videos list.
+func setDetails(videos []YtPlaylistItemSnippet, indexes []int) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	index := make(map[string]int)
+	var ids []string
+	for _, i := range indexes {
+		index[videos[i].ResourceId.VideoId] = i
+		ids = append(ids, videos[i].ResourceId.VideoId)
+	}
+
+	ytvideos, err := ytVideos(ids, YtVideoParts)
+	if err != nil {
+		return err
+	}
+	for _, ytid := range ids {
+		videos[index[ytid]].Details = &YtVideoDetails{Missing: true}
+	}
+	for i := range ytvideos {
+		vid := &videos[index[ytvideos[i].Id]]
+		details, err := ytvideos[i].details()
+		if err != nil {
+			log("WARNING: %v", err)
+			vid.Details = nil
+			continue
+		}
+		vid.Details = details
+	}
+
+	return nil
+}
+
+// publishedAt is when the video was published for the filters and the captions, from the details when it has them.
+// The listing PublishedAt, when the video was added to the playlist, stays what the audio names are made of.
+func (vid YtPlaylistItemSnippet) publishedAt() string {
+	if vid.Details != nil && vid.Details.PublishedAt != "" {
+		return vid.Details.PublishedAt
+	}
+	return vid.PublishedAt
+}
+
+// Waiting returns why the video can not be mirrored yet, empty if it can:
+// an upcoming premiere or broadcast, a broadcast still live, or a video still processing.
+func (d *YtVideoDetails) Waiting() string {
+	if d == nil {
+		return ""
+	}
+	switch d.LiveBroadcastContent {
+	case "upcoming":
+		if d.LiveStreaming != nil && d.LiveStreaming.ScheduledStartTime != "" {
+			return "upcoming broadcast scheduled at " + d.LiveStreaming.ScheduledStartTime
+		}
+		return "upcoming broadcast"
+	case "live":
+		return "live broadcast"
+	}
+	if d.LiveStreaming != nil && d.LiveStreaming.ActualStartTime != "" && d.LiveStreaming.ActualEndTime == "" {
+		return "live broadcast"
+	}
+	if d.UploadStatus == "uploaded" {
+		return "video uploaded, not processed yet"
+	}
+	return ""
+}
+
+// VideoWaitingError is a download refused for an upcoming premiere or broadcast,
+// it tells the same as Waiting when there are no details to tell it from.
+type VideoWaitingError struct {
+	Reason string
+}
+
+func (err *VideoWaitingError) Error() string {
+	return "waiting: " + err.Reason
+}
+
+// deferVideo keeps the video pending without counting an attempt, recording why it waits.
+func (m *Mirror) deferVideo(vidnum int, entry *LedgerEntry, reason string) error {
+	reason = "waiting: " + reason
+	if entry.Status == VideoPending && entry.Reason == reason {
+		return nil
+	}
+	log("Deferred #%d %s: %s", vidnum+1, entry.AudioName, reason)
+	entry.Status = VideoPending
+	entry.Reason = reason
+	if err := m.LedgerPut(entry); err != nil {
+		return fmt.Errorf("LedgerPut %s: %v", entry.VideoId, err)
+	}
+	return nil
+}
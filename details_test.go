@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEnrichVideosPublishedAt(t *testing.T) {
+	testEnv(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var videos YtVideoListResponse
+		video := YtVideo{Id: testVideoId(2)}
+		video.Snippet.PublishedAt = "2020-06-01T00:00:00Z"
+		video.ContentDetails.Duration = "PT1M"
+		videos.Items = append(videos.Items, video)
+		json.NewEncoder(w).Encode(videos)
+	}))
+	key := YtKey
+	t.Cleanup(func() { YtKey = key })
+	YtKey = "testkey"
+
+	m := &Mirror{}
+	videos := []YtPlaylistItemSnippet{testVideo(1), testVideo(2)}
+	audioName := videoAudioName(videos[1])
+	if err := m.enrichVideos(videos); err != nil {
+		t.Fatalf("enrichVideos: %v", err)
+	}
+
+	if videos[1].PublishedAt != testVideo(2).PublishedAt || videoAudioName(videos[1]) != audioName {
+		t.Errorf("listing publishedAt changed to %s", videos[1].PublishedAt)
+	}
+	if got := videos[1].publishedAt(); got != "2020-06-01T00:00:00Z" {
+		t.Errorf("publishedAt %s, want the one of the details", got)
+	}
+	if videos[0].ResourceId.VideoId != testVideoId(1) {
+		t.Errorf("videos sorted again")
+	}
+}
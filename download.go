@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+var (
+	// YtChunkSize is the size of the ranged requests, youtube throttles the streams read in one request
+	YtChunkSize int64 = 10 << 20
+	// YtDownloadRetries is how many times a failed chunk is requested again
+	YtDownloadRetries = 5
+	// YtRetryBackoff is the wait before the first retry, it doubles on each one
+	YtRetryBackoff = time.Second
+)
+
+type DownloadStats struct {
+	Size     int64
+	Retries  int
+	Duration time.Duration
+}
+
+func (s DownloadStats) String() string {
+	var rate int64
+	if s.Duration > 0 {
+		rate = int64(float64(s.Size) / s.Duration.Seconds())
+	}
+	return fmt.Sprintf("%dmb in %v at %dkb/s with %d retries", s.Size/1000/1000, s.Duration.Round(time.Second), rate/1000, s.Retries)
+}
+
+// httpStatusError is a response status which retrying does not fix.
+type httpStatusError struct {
+	Status string
+}
+
+func (err *httpStatusError) Error() string {
+	return "response status: " + err.Status
+}
+
+// downloadRanged downloads the url to the file in ranged chunks, retrying with exponential backoff
+// and resuming from the last received byte. The size is verified when it is known, 0 means it is not.
+func downloadRanged(ctx context.Context, url string, size int64, path string) (stats DownloadStats, err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return stats, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	start := time.Now()
+	backoff := YtRetryBackoff
+	var retries int
+	for size == 0 || stats.Size < size {
+		end := stats.Size + YtChunkSize - 1
+		if size == 0 {
+			end = -1
+		} else if end >= size {
+			end = size - 1
+		}
+
+		n, done, err := downloadRange(ctx, url, stats.Size, end, f)
+		stats.Size += n
+		if err == nil {
+			retries = 0
+			backoff = YtRetryBackoff
+			if done {
+				break
+			}
+			continue
+		}
+
+		if _, ok := err.(*httpStatusError); ok || ctx.Err() != nil || retries >= YtDownloadRetries {
+			stats.Duration = time.Since(start)
+			return stats, fmt.Errorf("at %d of %d bytes: %w", stats.Size, size, err)
+		}
+		retries++
+		stats.Retries++
+		log("Download at %d of %d bytes: %v, retry %d/%d in %v", stats.Size, size, err, retries, YtDownloadRetries, backoff)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	stats.Duration = time.Since(start)
+
+	if size > 0 && stats.Size != size {
+		return stats, fmt.Errorf("downloaded %d bytes, expected %d", stats.Size, size)
+	}
+	return stats, nil
+}
+
+// downloadRange appends the bytes from start to end, or to the end of the stream if end is -1, to w.
+// done reports that the stream has no more bytes.
+func downloadRange(ctx context.Context, url string, start, end int64, w io.Writer) (n int64, done bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if end >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+
+	resp, err := YtCl.HTTPClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && end < 0:
+		return 0, true, nil
+	case resp.StatusCode == http.StatusOK && start > 0:
+		return 0, false, &httpStatusError{Status: resp.Status + ", the range is ignored"}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return 0, false, fmt.Errorf("response status: %s", resp.Status)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		return 0, false, &httpStatusError{Status: resp.Status}
+	}
+
+	n, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return n, false, err
+	}
+	// the whole stream when the range is ignored
+	if resp.StatusCode == http.StatusOK || end < 0 {
+		return n, true, nil
+	}
+	if n != end-start+1 {
+		return n, false, fmt.Errorf("got %d bytes of the %d requested", n, end-start+1)
+	}
+	return n, false, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// testStream serves the body with ranges, cutting the response of the first request after Cut bytes
+// like a dropped connection.
+type testStream struct {
+	Body []byte
+	Cut  int
+	// Status is returned instead of the body when set
+	Status int
+
+	ranges []string
+}
+
+func (s *testStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rng := r.Header.Get("Range")
+	s.ranges = append(s.ranges, rng)
+	if s.Status != 0 {
+		w.WriteHeader(s.Status)
+		return
+	}
+
+	bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+	start, _ := strconv.Atoi(bounds[0])
+	end := len(s.Body) - 1
+	if bounds[1] != "" {
+		end, _ = strconv.Atoi(bounds[1])
+	}
+	if start >= len(s.Body) {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if end >= len(s.Body) {
+		end = len(s.Body) - 1
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.Body)))
+	w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+	w.WriteHeader(http.StatusPartialContent)
+	if len(s.ranges) == 1 && s.Cut > 0 {
+		w.Write(s.Body[start : start+s.Cut])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(s.Body[start : end+1])
+}
+
+func testDownloadRanged(t *testing.T, stream *testStream, size int64) (DownloadStats, []byte, error) {
+	srv := httptest.NewServer(stream)
+	cl, chunkSize, backoff := YtCl, YtChunkSize, YtRetryBackoff
+	t.Cleanup(func() {
+		srv.Close()
+		YtCl, YtChunkSize, YtRetryBackoff = cl, chunkSize, backoff
+	})
+	YtCl = yt.Client{HTTPClient: srv.Client()}
+	YtChunkSize = 1000
+	YtRetryBackoff = time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "stream")
+	stats, err := downloadRanged(context.Background(), srv.URL, size, path)
+	b, rerr := ioutil.ReadFile(path)
+	if rerr != nil {
+		t.Fatalf("ReadFile: %v", rerr)
+	}
+	return stats, b, err
+}
+
+func testBody(size int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestDownloadRangedResume(t *testing.T) {
+	stream := &testStream{Body: testBody(2500), Cut: 300}
+	stats, b, err := testDownloadRanged(t, stream, 2500)
+	if err != nil {
+		t.Fatalf("downloadRanged: %v", err)
+	}
+
+	if !bytes.Equal(b, stream.Body) {
+		t.Errorf("downloaded %d bytes differ from the %d bytes of the stream", len(b), len(stream.Body))
+	}
+	if stats.Size != 2500 || stats.Retries != 1 {
+		t.Errorf("stats size %d retries %d, want 2500 and 1", stats.Size, stats.Retries)
+	}
+	want := []string{"bytes=0-999", "bytes=300-1299", "bytes=1300-2299", "bytes=2300-2499"}
+	if !reflect.DeepEqual(stream.ranges, want) {
+		t.Errorf("ranges %v, want %v", stream.ranges, want)
+	}
+}
+
+func TestDownloadRangedUnknownSize(t *testing.T) {
+	stream := &testStream{Body: testBody(2500), Cut: 700}
+	stats, b, err := testDownloadRanged(t, stream, 0)
+	if err != nil {
+		t.Fatalf("downloadRanged: %v", err)
+	}
+
+	if !bytes.Equal(b, stream.Body) {
+		t.Errorf("downloaded %d bytes differ from the %d bytes of the stream", len(b), len(stream.Body))
+	}
+	if stats.Size != 2500 || stats.Retries != 1 {
+		t.Errorf("stats size %d retries %d, want 2500 and 1", stats.Size, stats.Retries)
+	}
+	want := []string{"bytes=0-", "bytes=700-"}
+	if !reflect.DeepEqual(stream.ranges, want) {
+		t.Errorf("ranges %v, want %v", stream.ranges, want)
+	}
+}
+
+func TestDownloadRangedForbidden(t *testing.T) {
+	stream := &testStream{Status: http.StatusForbidden}
+	_, _, err := testDownloadRanged(t, stream, 2500)
+
+	var serr *httpStatusError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got %v, want a response status error", err)
+	}
+	if len(stream.ranges) != 1 {
+		t.Errorf("%d requests, want no retries of a forbidden stream", len(stream.ranges))
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// Downloader downloads the audio or the video of a youtube video to a file,
+// picking the streams by the mirror audio format policy and video height limit.
+type Downloader interface {
+	Name() string
+	DownloadAudio(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error)
+	// DownloadVideo makes an mp4 file with one video and one audio stream
+	DownloadVideo(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error)
+}
+
+type Download struct {
+	Downloader string
+	// Format describes the downloaded streams, it is recorded in the ledger
+	Format   string
+	Duration time.Duration
+	Stats    DownloadStats
+
+	// Width and Height are of the video, Remux is set when its streams are h264 and aac
+	Width  int
+	Height int
+	Remux  bool
+}
+
+// The error classes on which the next downloader is tried: extracting the stream url fails
+// when youtube changes the player and the library is not updated yet, and the stream is refused when the url is wrong.
+const (
+	DownloadExtract = "extract"
+	DownloadStream  = "stream"
+)
+
+type DownloaderError struct {
+	Downloader string
+	Class      string
+	Err        error
+}
+
+func (err *DownloaderError) Error() string {
+	return fmt.Sprintf("%s %s: %v", err.Downloader, err.Class, err.Err)
+}
+
+func (err *DownloaderError) Unwrap() error {
+	return err.Err
+}
+
+var (
+	// YtDownloaders are the downloaders in the order they are tried
+	YtDownloaders string = "kkdai yt-dlp"
+
+	YtDlpPath string = "./yt-dlp"
+	// YtDlpArgs are added to the yt-dlp arguments, like --cookies cookies.txt
+	YtDlpArgs string
+)
+
+var Downloaders = map[string]Downloader{
+	"kkdai":  ytClientDownloader{},
+	"yt-dlp": ytDlpDownloader{},
+}
+
+func parseDownloaders(s string) (downloaders []Downloader, err error) {
+	for _, name := range strings.Fields(s) {
+		d, ok := Downloaders[name]
+		if !ok {
+			return nil, fmt.Errorf("downloader %#v: expected kkdai or yt-dlp", name)
+		}
+		downloaders = append(downloaders, d)
+	}
+	if len(downloaders) == 0 {
+		return nil, fmt.Errorf("no downloaders")
+	}
+	return downloaders, nil
+}
+
+func (m *Mirror) downloadAudio(ytid string, path string) (*Download, error) {
+	return m.download(func(d Downloader) (*Download, error) { return d.DownloadAudio(Ctx, m, ytid, path) })
+}
+
+func (m *Mirror) downloadVideo(ytid string, path string) (*Download, error) {
+	return m.download(func(d Downloader) (*Download, error) { return d.DownloadVideo(Ctx, m, ytid, path) })
+}
+
+// download tries the mirror downloaders in order, going to the next one on the DownloaderError errors only.
+func (m *Mirror) download(get func(Downloader) (*Download, error)) (download *Download, err error) {
+	var errs []string
+	for i, d := range m.downloaders {
+		download, err = get(d)
+		if err == nil {
+			return download, nil
+		}
+		var derr *DownloaderError
+		if !errors.As(err, &derr) || Ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, err.Error())
+		if i+1 < len(m.downloaders) {
+			log("WARNING: %v, falling back to %s", err, m.downloaders[i+1].Name())
+		}
+	}
+	return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+// ytClientDownloader is the kkdai/youtube client with the ranged download.
+type ytClientDownloader struct{}
+
+func (ytClientDownloader) Name() string {
+	return "kkdai"
+}
+
+func (d ytClientDownloader) DownloadAudio(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error) {
+	vinfo, err := d.video(ctx, ytid)
+	if err != nil {
+		return nil, err
+	}
+
+	audioFormat, err := m.selectAudioFormat(vinfo)
+	if err != nil {
+		return nil, &DownloaderError{Downloader: d.Name(), Class: DownloadExtract, Err: err}
+	}
+	log("Audio format %s: %s", m.AudioFormat, describeFormat(audioFormat))
+
+	stats, err := d.download(ctx, vinfo, audioFormat, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Download{
+		Downloader: d.Name(),
+		Format:     describeFormat(audioFormat),
+		Duration:   vinfo.Duration,
+		Stats:      stats,
+	}, nil
+}
+
+func (d ytClientDownloader) DownloadVideo(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error) {
+	vinfo, err := d.video(ctx, ytid)
+	if err != nil {
+		return nil, err
+	}
+
+	videoFormat, audioFormat, err := m.selectVideoFormats(vinfo)
+	if err != nil {
+		return nil, &DownloaderError{Downloader: d.Name(), Class: DownloadExtract, Err: err}
+	}
+	download := &Download{
+		Downloader: d.Name(),
+		Format:     describeFormat(videoFormat),
+		Duration:   vinfo.Duration,
+		Width:      videoFormat.Width,
+		Height:     videoFormat.Height,
+		Remux:      strings.Contains(videoFormat.MimeType, "avc1"),
+	}
+
+	if audioFormat == nil {
+		log("Video format: %s", download.Format)
+		download.Stats, err = d.download(ctx, vinfo, videoFormat, path)
+		if err != nil {
+			return nil, err
+		}
+		return download, nil
+	}
+
+	download.Format += " + " + describeFormat(*audioFormat)
+	download.Remux = download.Remux && strings.HasPrefix(audioFormat.MimeType, "audio/mp4")
+	log("Video format %s: %s", m.AudioFormat, download.Format)
+
+	videoPath, audioPath := path+".video", path+".audio"
+	defer removeFile(videoPath)
+	defer removeFile(audioPath)
+	videoStats, err := d.download(ctx, vinfo, videoFormat, videoPath)
+	if err != nil {
+		return nil, err
+	}
+	audioStats, err := d.download(ctx, vinfo, *audioFormat, audioPath)
+	if err != nil {
+		return nil, err
+	}
+	download.Stats = DownloadStats{
+		Size:     videoStats.Size + audioStats.Size,
+		Retries:  videoStats.Retries + audioStats.Retries,
+		Duration: videoStats.Duration + audioStats.Duration,
+	}
+
+	err = exec.CommandContext(
+		ctx,
+		FfmpegPath, "-v", "panic", "-y",
+		"-i", videoPath, "-i", audioPath,
+		"-map", "0:v:0", "-map", "1:a:0", "-c", "copy",
+		"-f", "mp4", path,
+	).Run()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg merge: %v", err)
+	}
+
+	return download, nil
+}
+
+// video gets the video info, the playability errors are classified.
+func (d ytClientDownloader) video(ctx context.Context, ytid string) (*yt.Video, error) {
+	vinfo, err := YtCl.GetVideoContext(ctx, ytid)
+	switch cerr := classifyYtError(err).(type) {
+	case *VideoUnavailableError, *VideoWaitingError:
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, &DownloaderError{Downloader: d.Name(), Class: DownloadExtract, Err: fmt.Errorf("GetVideoContext: %v", err)}
+	}
+	return vinfo, nil
+}
+
+func (d ytClientDownloader) download(ctx context.Context, vinfo *yt.Video, format yt.Format, path string) (DownloadStats, error) {
+	streamUrl, err := YtCl.GetStreamURLContext(ctx, vinfo, &format)
+	if err != nil {
+		return DownloadStats{}, &DownloaderError{Downloader: d.Name(), Class: DownloadExtract, Err: fmt.Errorf("GetStreamURLContext: %v", err)}
+	}
+
+	stats, err := downloadRanged(ctx, streamUrl, format.ContentLength, path)
+	var serr *httpStatusError
+	if errors.As(err, &serr) {
+		return stats, &DownloaderError{Downloader: d.Name(), Class: DownloadStream, Err: err}
+	}
+	if err != nil {
+		return stats, fmt.Errorf("Download itag %d: %v", format.ItagNo, err)
+	}
+	return stats, nil
+}
+
+// ytDlpDownloader runs the yt-dlp executable at YtDlpPath.
+type ytDlpDownloader struct{}
+
+func (ytDlpDownloader) Name() string {
+	return "yt-dlp"
+}
+
+// ytDlpInfo are the fields of the yt-dlp info json the download is described by.
+type ytDlpInfo struct {
+	FormatId string  `json:"format_id"`
+	Format   string  `json:"format"`
+	Ext      string  `json:"ext"`
+	Abr      float64 `json:"abr"`
+	Tbr      float64 `json:"tbr"`
+	Language string  `json:"language"`
+	Duration float64 `json:"duration"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Vcodec   string  `json:"vcodec"`
+	Acodec   string  `json:"acodec"`
+}
+
+// ytDlpFormat makes the yt-dlp format selector of the audio format policy,
+// with the audio only streams first and the muxed ones as the last resort.
+func ytDlpFormat(p AudioFormatPolicy) string {
+	audio, muxed := "ba", "b"
+	if p.Select == "lowest" {
+		audio, muxed = "wa", "w"
+	}
+
+	var ext string
+	switch p.Prefer {
+	case "mp4":
+		ext = "[ext=m4a]"
+	case "opus":
+		ext = "[ext=webm]"
+	}
+	var lang string
+	if p.Language != "" {
+		lang = "[language^=" + p.Language + "]"
+	}
+
+	var selectors []string
+	for _, s := range []string{audio + ext + lang, audio + lang, audio + ext, audio, muxed} {
+		if !containsString(selectors, s) {
+			selectors = append(selectors, s)
+		}
+	}
+	return strings.Join(selectors, "/")
+}
+
+func (d ytDlpDownloader) DownloadAudio(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error) {
+	args := []string{"--format", ytDlpFormat(m.AudioFormat)}
+	if m.AudioFormat.Select == "closest" {
+		target, err := parseBitrate(m.TgAudioBitrate)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--format-sort", fmt.Sprintf("abr~%d", target/1000))
+	}
+
+	info, download, err := d.run(ctx, ytid, path, args)
+	if err != nil {
+		return nil, err
+	}
+
+	bitrate := info.Abr
+	if bitrate == 0 {
+		bitrate = info.Tbr
+	}
+	download.Format = fmt.Sprintf("yt-dlp:%s %s bitrate:%dkbps", info.FormatId, info.Ext, int(bitrate))
+	if info.Language != "" {
+		download.Format += " lang:" + info.Language
+	}
+	log("Audio format %s: %s", m.AudioFormat, download.Format)
+
+	return download, nil
+}
+
+// DownloadVideo prefers h264 and aac which only need remuxing, the path should end with .mp4 as yt-dlp corrects the extension.
+func (d ytDlpDownloader) DownloadVideo(ctx context.Context, m *Mirror, ytid string, path string) (*Download, error) {
+	h := fmt.Sprintf("[height<=%d]", m.TgVideoMaxHeight)
+	format := strings.Join([]string{
+		"bv*" + h + "[vcodec^=avc1]+ba[ext=m4a]",
+		"b" + h + "[ext=mp4]",
+		"bv*" + h + "+ba",
+		"b" + h,
+		"wv*+ba",
+		"w",
+	}, "/")
+
+	info, download, err := d.run(ctx, ytid, path, []string{"--format", format, "--merge-output-format", "mp4"})
+	if err != nil {
+		return nil, err
+	}
+
+	download.Format = fmt.Sprintf("yt-dlp:%s %dx%d %s %s", info.FormatId, info.Width, info.Height, info.Vcodec, info.Acodec)
+	download.Width, download.Height = info.Width, info.Height
+	download.Remux = strings.HasPrefix(info.Vcodec, "avc1") && strings.HasPrefix(info.Acodec, "mp4a")
+	log("Video format: %s", download.Format)
+
+	return download, nil
+}
+
+// run runs yt-dlp with the format args, the stats and the duration of the download are set from the info json.
+func (d ytDlpDownloader) run(ctx context.Context, ytid string, path string, formatArgs []string) (info ytDlpInfo, download *Download, err error) {
+	args := []string{
+		"--quiet", "--no-warnings", "--no-playlist", "--no-part", "--force-overwrites",
+		"--no-simulate", "--dump-json",
+		"--output", path,
+	}
+	args = append(args, formatArgs...)
+	args = append(args, strings.Fields(YtDlpArgs)...)
+	args = append(args, "--", ytid)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, YtDlpPath, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	start := time.Now()
+	if err = cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if uerr := classifyYtDlpError(msg); uerr != nil {
+			return info, nil, uerr
+		}
+		if msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return info, nil, &DownloaderError{Downloader: d.Name(), Class: DownloadExtract, Err: err}
+	}
+	duration := time.Since(start)
+
+	if err = json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return info, nil, fmt.Errorf("yt-dlp info json: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return info, nil, fmt.Errorf("yt-dlp output: %v", err)
+	}
+
+	return info, &Download{
+		Downloader: d.Name(),
+		Duration:   time.Duration(info.Duration * float64(time.Second)),
+		Stats:      DownloadStats{Size: fi.Size(), Duration: duration},
+	}, nil
+}
+
+// classifyYtDlpError makes a VideoUnavailableError or a VideoWaitingError of the yt-dlp error messages,
+// nil for the other errors.
+func classifyYtDlpError(msg string) error {
+	r := strings.ToLower(msg)
+	switch {
+	case strings.Contains(r, "premieres in") || strings.Contains(r, "premiere will begin") || strings.Contains(r, "live event will begin"):
+		return &VideoWaitingError{Reason: msg}
+	case strings.Contains(r, "private video"):
+		return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: msg}
+	case strings.Contains(r, "confirm your age") || strings.Contains(r, "age-restricted"):
+		return &VideoUnavailableError{Kind: UnavailableAgeRestricted, Reason: msg}
+	case strings.Contains(r, "in your country"):
+		return &VideoUnavailableError{Kind: UnavailableRegionBlocked, Reason: msg}
+	case strings.Contains(r, "has been removed") || strings.Contains(r, "account associated with this video has been terminated"):
+		return &VideoUnavailableError{Kind: UnavailableDeleted, Reason: msg}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	YtFeedBaseUrl  = "https://www.youtube.com/feeds/videos.xml"
+	YtThumbBaseUrl = "https://i.ytimg.com/vi/"
+)
+
+type YtFeed struct {
+	XMLName xml.Name      `xml:"http://www.w3.org/2005/Atom feed"`
+	Entries []YtFeedEntry `xml:"http://www.w3.org/2005/Atom entry"`
+}
+
+type YtFeedEntry struct {
+	VideoId   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+	Title     string `xml:"http://www.w3.org/2005/Atom title"`
+	Published string `xml:"http://www.w3.org/2005/Atom published"`
+	Group     struct {
+		Description string `xml:"http://search.yahoo.com/mrss/ description"`
+		Thumbnail   struct {
+			Url string `xml:"url,attr"`
+		} `xml:"http://search.yahoo.com/mrss/ thumbnail"`
+	} `xml:"http://search.yahoo.com/mrss/ group"`
+}
+
+func getFeed(param, id string) (*YtFeed, error) {
+	FeedUrl, err := url.Parse(YtFeedBaseUrl)
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse: %v", err)
+	}
+	FeedUrlValues := FeedUrl.Query()
+	FeedUrlValues.Set(param, id)
+	FeedUrl.RawQuery = FeedUrlValues.Encode()
+
+	resp, err := HttpClient.Get(FeedUrl.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed %s=%s: response status: %s", param, id, resp.Status)
+	}
+
+	var feed YtFeed
+	if err = xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("feed %s=%s: Decode: %v", param, id, err)
+	}
+	return &feed, nil
+}
+
+// snippet maps a feed entry to the fields a playlist item has.
+func (e YtFeedEntry) snippet() (vid YtPlaylistItemSnippet, err error) {
+	publishedAt, err := time.Parse(time.RFC3339, e.Published)
+	if err != nil {
+		return vid, fmt.Errorf("feed entry %s: published: %v", e.VideoId, err)
+	}
+
+	vid.ResourceId.VideoId = e.VideoId
+	vid.Title = e.Title
+	vid.Description = e.Group.Description
+	vid.PublishedAt = publishedAt.UTC().Format("2006-01-02T15:04:05Z")
+
+	// the feed has the hqdefault thumbnail only, the other sizes are next to it
+	thumb := e.Group.Thumbnail.Url
+	vid.Thumbnails.High.Url = thumb
+	if strings.Contains(thumb, "/hqdefault.") {
+		vid.Thumbnails.Medium.Url = strings.Replace(thumb, "/hqdefault.", "/mqdefault.", 1)
+	} else {
+		vid.Thumbnails.Medium.Url = thumb
+	}
+
+	return vid, nil
+}
+
+// listFeed lists the mirror videos from the public feeds which need no api key
+// but only have the 15 most recent videos of a channel or a playlist.
+func (m *Mirror) listFeed() (videos []YtPlaylistItemSnippet, err error) {
+	sources, err := m.resolveSources()
+	if err != nil {
+		return nil, err
+	}
+	playlistIds := append([]string{}, m.YtPlaylistId...)
+	var channelIds []string
+	if m.YtChannelId != "" {
+		channelIds = append(channelIds, m.YtChannelId)
+	}
+	for _, r := range sources {
+		if r.ChannelId != "" {
+			channelIds = append(channelIds, r.ChannelId)
+		} else if r.PlaylistId != "" {
+			playlistIds = append(playlistIds, r.PlaylistId)
+		}
+	}
+
+	var feeds []*YtFeed
+	var feedPlaylists []string
+	for _, plid := range playlistIds {
+		feed, err := getFeed("playlist_id", plid)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+		feedPlaylists = append(feedPlaylists, plid)
+	}
+	for _, chid := range channelIds {
+		feed, err := getFeed("channel_id", chid)
+		if err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+		feedPlaylists = append(feedPlaylists, uploadsPlaylistId(chid))
+	}
+
+	sourceVideos, err := m.sourceVideos()
+	if err != nil {
+		return nil, err
+	}
+	if len(feeds) == 0 && len(sourceVideos) == 0 {
+		return nil, fmt.Errorf("feed source needs YtChannelId, YtPlaylistId or YtSources")
+	}
+	videos = append(videos, sourceVideos...)
+
+	for n, feed := range feeds {
+		for _, e := range feed.Entries {
+			vid, err := e.snippet()
+			if err != nil {
+				return nil, err
+			}
+			vid.Playlists = []string{feedPlaylists[n]}
+			videos = append(videos, vid)
+		}
+	}
+
+	return mergeVideos(videos), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <title>Channel</title>
+ <entry>
+  <yt:videoId>bbbbbbbbbbb</yt:videoId>
+  <title>Second</title>
+  <published>2021-03-02T10:00:00+00:00</published>
+  <media:group>
+   <media:title>Second</media:title>
+   <media:thumbnail url="https://i2.ytimg.com/vi/bbbbbbbbbbb/hqdefault.jpg" width="480" height="360"/>
+   <media:description>The second video</media:description>
+  </media:group>
+ </entry>
+ <entry>
+  <yt:videoId>aaaaaaaaaaa</yt:videoId>
+  <title>First</title>
+  <published>2021-03-01T12:30:00+02:00</published>
+  <media:group>
+   <media:title>First</media:title>
+   <media:thumbnail url="https://i2.ytimg.com/vi/aaaaaaaaaaa/default.jpg" width="120" height="90"/>
+   <media:description>The first video</media:description>
+  </media:group>
+ </entry>
+</feed>
+`
+
+func TestListFeed(t *testing.T) {
+	var queries []string
+	testEnv(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feeds/videos.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		if r.URL.Query().Get("playlist_id") == "PLmissing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testFeed)
+	}))
+
+	m := &Mirror{YtSource: "feed", YtChannelId: "UCchannel", YtPlaylistId: []string{"PLlist"}}
+	videos, err := m.listFeed()
+	if err != nil {
+		t.Fatalf("listFeed: %v", err)
+	}
+
+	if want := []string{"playlist_id=PLlist", "channel_id=UCchannel"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries %v, want %v", queries, want)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("got %d videos, want 2", len(videos))
+	}
+
+	first, second := videos[0], videos[1]
+	if first.ResourceId.VideoId != "aaaaaaaaaaa" || second.ResourceId.VideoId != "bbbbbbbbbbb" {
+		t.Errorf("videos %s %s, want them sorted by publishing time", first.ResourceId.VideoId, second.ResourceId.VideoId)
+	}
+	if first.PublishedAt != "2021-03-01T10:30:00Z" {
+		t.Errorf("published %s, want it in utc", first.PublishedAt)
+	}
+	if first.Title != "First" || first.Description != "The first video" {
+		t.Errorf("title %#v description %#v", first.Title, first.Description)
+	}
+	if want := []string{"PLlist", "UUchannel"}; !reflect.DeepEqual(first.Playlists, want) {
+		t.Errorf("playlists %v, want %v", first.Playlists, want)
+	}
+	if second.Thumbnails.Medium.Url != "https://i2.ytimg.com/vi/bbbbbbbbbbb/mqdefault.jpg" {
+		t.Errorf("medium thumbnail %s", second.Thumbnails.Medium.Url)
+	}
+	if first.Thumbnails.Medium.Url != first.Thumbnails.High.Url {
+		t.Errorf("medium thumbnail %s, want the feed one", first.Thumbnails.Medium.Url)
+	}
+
+	m = &Mirror{YtSource: "feed", YtPlaylistId: []string{"PLmissing"}}
+	if _, err = m.listFeed(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("listFeed of a missing playlist: %v, want the response status", err)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// VideoFilter leaves videos of a mirror out, the regexps are matched against the title and the description,
+// the dates are YYYY-MM-DD, the duration and type rules need the api source or YtKey.
+type VideoFilter struct {
+	TitleInclude       string `yaml:"titleInclude"`
+	TitleExclude       string `yaml:"titleExclude"`
+	DescriptionInclude string `yaml:"descriptionInclude"`
+	DescriptionExclude string `yaml:"descriptionExclude"`
+
+	MinDuration time.Duration `yaml:"minDuration"`
+	MaxDuration time.Duration `yaml:"maxDuration"`
+
+	PublishedAfter  string `yaml:"publishedAfter"`
+	PublishedBefore string `yaml:"publishedBefore"`
+
+	SkipShorts    bool `yaml:"skipShorts"`
+	SkipLive      bool `yaml:"skipLive"`
+	SkipPremieres bool `yaml:"skipPremieres"`
+
+	titleInclude       *regexp.Regexp
+	titleExclude       *regexp.Regexp
+	descriptionInclude *regexp.Regexp
+	descriptionExclude *regexp.Regexp
+	publishedAfter     time.Time
+	publishedBefore    time.Time
+}
+
+// YtFilter is the filter of the environment mirror in yaml flow style, like {skipShorts: true, maxDuration: 2h}.
+var YtFilter string
+
+func (f *VideoFilter) compile() (err error) {
+	for _, re := range []struct {
+		name string
+		expr string
+		re   **regexp.Regexp
+	}{
+		{"titleInclude", f.TitleInclude, &f.titleInclude},
+		{"titleExclude", f.TitleExclude, &f.titleExclude},
+		{"descriptionInclude", f.DescriptionInclude, &f.descriptionInclude},
+		{"descriptionExclude", f.DescriptionExclude, &f.descriptionExclude},
+	} {
+		if re.expr == "" {
+			continue
+		}
+		if *re.re, err = regexp.Compile(re.expr); err != nil {
+			return fmt.Errorf("filter %s: %v", re.name, err)
+		}
+	}
+
+	if f.PublishedAfter != "" {
+		if f.publishedAfter, err = time.Parse("2006-01-02", f.PublishedAfter); err != nil {
+			return fmt.Errorf("filter publishedAfter: %v", err)
+		}
+	}
+	if f.PublishedBefore != "" {
+		if f.publishedBefore, err = time.Parse("2006-01-02", f.PublishedBefore); err != nil {
+			return fmt.Errorf("filter publishedBefore: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// needsDetails reports whether the filter needs the videos list of the data api.
+func (f *VideoFilter) needsDetails() bool {
+	return f.MinDuration > 0 || f.MaxDuration > 0 || f.SkipShorts || f.SkipLive || f.SkipPremieres
+}
+
+// skipReason returns why the filter leaves the video out, empty if it does not.
+func (f *VideoFilter) skipReason(vid YtPlaylistItemSnippet) (string, error) {
+	if f.titleInclude != nil && !f.titleInclude.MatchString(vid.Title) {
+		return "title does not match " + f.TitleInclude, nil
+	}
+	if f.titleExclude != nil && f.titleExclude.MatchString(vid.Title) {
+		return "title matches " + f.TitleExclude, nil
+	}
+	if f.descriptionInclude != nil && !f.descriptionInclude.MatchString(vid.Description) {
+		return "description does not match " + f.DescriptionInclude, nil
+	}
+	if f.descriptionExclude != nil && f.descriptionExclude.MatchString(vid.Description) {
+		return "description matches " + f.DescriptionExclude, nil
+	}
+
+	if !f.publishedAfter.IsZero() || !f.publishedBefore.IsZero() {
+		publishedAt, err := time.Parse(time.RFC3339, vid.publishedAt())
+		if err != nil {
+			return "", fmt.Errorf("publishedAt: %v", err)
+		}
+		if !f.publishedAfter.IsZero() && publishedAt.Before(f.publishedAfter) {
+			return "published before " + f.PublishedAfter, nil
+		}
+		if !f.publishedBefore.IsZero() && !publishedAt.Before(f.publishedBefore) {
+			return "published after " + f.PublishedBefore, nil
+		}
+	}
+
+	if !f.needsDetails() {
+		return "", nil
+	}
+	details := vid.Details
+	if details == nil {
+		return "", fmt.Errorf("video details not found")
+	}
+
+	duration := details.Duration
+	if f.MinDuration > 0 && duration < f.MinDuration {
+		return fmt.Sprintf("duration %v shorter than %v", duration, f.MinDuration), nil
+	}
+	if f.MaxDuration > 0 && duration > f.MaxDuration {
+		return fmt.Sprintf("duration %v longer than %v", duration, f.MaxDuration), nil
+	}
+
+	if details.LiveStreaming != nil && (f.SkipLive || f.SkipPremieres) {
+		// premieres have live streaming details too, but are not live content
+		live, err := ytIsLiveContent(vid.ResourceId.VideoId)
+		if err != nil {
+			return "", err
+		}
+		if live && f.SkipLive {
+			return "live stream", nil
+		}
+		if !live && f.SkipPremieres {
+			return "premiere", nil
+		}
+	}
+
+	// shorts are at most three minutes long
+	if f.SkipShorts && duration <= 3*time.Minute {
+		short, err := ytIsShort(vid.ResourceId.VideoId)
+		if err != nil {
+			return "", err
+		}
+		if short {
+			return "short", nil
+		}
+	}
+
+	return "", nil
+}
+
+var isoDurationRe = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseIsoDuration parses the ISO 8601 durations the data api returns, like PT1H2M3S.
+func parseIsoDuration(s string) (d time.Duration, err error) {
+	match := isoDurationRe.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("duration %#v: not an ISO 8601 duration", s)
+	}
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(match[i+1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("duration %#v: %v", s, err)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d, nil
+}
+
+var ytIsLiveContentRe = regexp.MustCompile(`"isLiveContent":(true|false)`)
+
+// ytIsLiveContent tells a live stream from a premiere by the watch page.
+func ytIsLiveContent(ytid string) (bool, error) {
+	resp, err := HttpClient.Get(YtWebBaseUrl + "/watch?v=" + ytid)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("watch page %s: response status: %s", ytid, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("watch page %s: %v", ytid, err)
+	}
+	match := ytIsLiveContentRe.FindSubmatch(body)
+	if match == nil {
+		return false, fmt.Errorf("watch page %s: isLiveContent not found", ytid)
+	}
+	return string(match[1]) == "true", nil
+}
+
+// ytIsShort checks the shorts url, it redirects to the watch page for the videos which are not shorts.
+func ytIsShort(ytid string) (bool, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Head(YtWebBaseUrl + "/shorts/" + ytid)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// filterSkips returns the reasons for the due videos the mirror filter leaves out,
+// and the videos it could not decide on which are held back until the next run.
+func (m *Mirror) filterSkips(videos []YtPlaylistItemSnippet) (skips map[string]string, held map[string]bool, err error) {
+	if m.Filter == nil {
+		return nil, nil, nil
+	}
+
+	var due []YtPlaylistItemSnippet
+	for _, vid := range videos {
+		entry, err := m.ledgerEntry(vid)
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry.Due() {
+			due = append(due, vid)
+		}
+	}
+
+	if m.Filter.needsDetails() {
+		if err = m.enrichVideos(due); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	skips = make(map[string]string)
+	held = make(map[string]bool)
+	for _, vid := range due {
+		ytid := vid.ResourceId.VideoId
+		// broadcasts are decided on when they are over
+		if vid.Details.Waiting() != "" {
+			held[ytid] = true
+			continue
+		}
+		// and unavailable videos are left to the unavailable policy
+		if unavailableVideo(vid) != nil {
+			continue
+		}
+		reason, err := m.Filter.skipReason(vid)
+		if err != nil {
+			log("WARNING: filter %s: %v, held back until the next run", ytid, err)
+			held[ytid] = true
+			continue
+		}
+		if reason != "" {
+			skips[ytid] = reason
+		}
+	}
+	return skips, held, nil
+}
+
+// LedgerFilter records the videos left out by the mirror filter as skipped, so they are not evaluated again,
+// and returns the held back videos.
+func (m *Mirror) LedgerFilter(videos []YtPlaylistItemSnippet) (held map[string]bool, err error) {
+	skips, held, err := m.filterSkips(videos)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*LedgerEntry
+	for _, vid := range videos {
+		reason, ok := skips[vid.ResourceId.VideoId]
+		if !ok {
+			continue
+		}
+		entry, err := m.ledgerEntry(vid)
+		if err != nil {
+			return nil, err
+		}
+		entry.Status = VideoSkipped
+		entry.Reason = "filter: " + reason
+		entries = append(entries, entry)
+		log("Filtered %s: %s", entry.AudioName, reason)
+	}
+	if err = m.LedgerPutAll(entries); err != nil {
+		return nil, err
+	}
+
+	return held, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseIsoDuration(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		d   time.Duration
+		err bool
+	}{
+		{s: "PT1H2M3S", d: time.Hour + 2*time.Minute + 3*time.Second},
+		{s: "PT45S", d: 45 * time.Second},
+		{s: "PT10M", d: 10 * time.Minute},
+		{s: "PT2H", d: 2 * time.Hour},
+		{s: "PT1H5S", d: time.Hour + 5*time.Second},
+		{s: "P1DT2H", d: 26 * time.Hour},
+		{s: "P2D", d: 48 * time.Hour},
+		{s: "PT0S", d: 0},
+		{s: "P0D", d: 0},
+		{s: "", err: true},
+		{s: "1H2M", err: true},
+		{s: "PT1.5S", err: true},
+		{s: "PT1S2M", err: true},
+	} {
+		d, err := parseIsoDuration(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%#v: got %v, want an error", tc.s, d)
+			}
+			continue
+		}
+		if err != nil || d != tc.d {
+			t.Errorf("%#v: got %v %v, want %v", tc.s, d, err, tc.d)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FirstRunPolicy decides which videos are mirrored when a mirror has no state yet:
+// "all" or empty mirrors the whole history, "mark-all-seen" mirrors nothing,
+// "last-N" mirrors the N most recent videos and "since=2006-01-02" the videos published from that date.
+type FirstRunPolicy struct {
+	Kind  string
+	N     int
+	Since time.Time
+}
+
+var YtFirstRun string
+
+func ParseFirstRunPolicy(s string) (p FirstRunPolicy, err error) {
+	switch {
+	case s == "" || s == "all":
+		p.Kind = "all"
+	case s == "mark-all-seen":
+		p.Kind = s
+	case strings.HasPrefix(s, "last-"):
+		p.Kind = "last"
+		p.N, err = strconv.Atoi(strings.TrimPrefix(s, "last-"))
+		if err != nil || p.N < 0 {
+			return p, fmt.Errorf("first run policy %#v: expected last-N with a number N", s)
+		}
+	case strings.HasPrefix(s, "since="):
+		p.Kind = "since"
+		p.Since, err = time.Parse("2006-01-02", strings.TrimPrefix(s, "since="))
+		if err != nil {
+			return p, fmt.Errorf("first run policy %#v: expected since=YYYY-MM-DD: %v", s, err)
+		}
+	default:
+		return p, fmt.Errorf("unknown first run policy %#v", s)
+	}
+	return p, nil
+}
+
+func (p FirstRunPolicy) String() string {
+	switch p.Kind {
+	case "last":
+		return fmt.Sprintf("last-%d", p.N)
+	case "since":
+		return "since=" + p.Since.Format("2006-01-02")
+	}
+	return p.Kind
+}
+
+// firstRun reports whether the mirror has no state at all.
+func (m *Mirror) firstRun() (bool, error) {
+	if m.Last != "" {
+		return false, nil
+	}
+	return m.ledgerEmpty()
+}
+
+// firstRunSkips returns the ids of the videos the first run policy leaves out,
+// videos are expected sorted by publishing time.
+func (m *Mirror) firstRunSkips(videos []YtPlaylistItemSnippet) map[string]bool {
+	skips := make(map[string]bool)
+	for i, vid := range videos {
+		var skip bool
+		switch m.firstRunPolicy.Kind {
+		case "mark-all-seen":
+			skip = true
+		case "last":
+			skip = i < len(videos)-m.firstRunPolicy.N
+		case "since":
+			publishedAt, err := time.Parse(time.RFC3339, vid.PublishedAt)
+			skip = err == nil && publishedAt.Before(m.firstRunPolicy.Since)
+		}
+		if skip {
+			skips[vid.ResourceId.VideoId] = true
+		}
+	}
+	return skips
+}
+
+// LedgerFirstRun moves YtLast to the newest video the first run policy leaves out,
+// the videos up to it are done without ledger entries.
+func (m *Mirror) LedgerFirstRun(videos []YtPlaylistItemSnippet) error {
+	if m.firstRunPolicy.Kind == "all" {
+		return nil
+	}
+	first, err := m.firstRun()
+	if err != nil || !first {
+		return err
+	}
+
+	skips := m.firstRunSkips(videos)
+	var newest string
+	for _, vid := range videos {
+		if !skips[vid.ResourceId.VideoId] {
+			continue
+		}
+		if audioName := videoAudioName(vid); audioName > newest {
+			newest = audioName
+		}
+	}
+
+	if newest != "" {
+		m.Last = newest
+		if err = State.Set(m.key("YtLast"), newest); err != nil {
+			return err
+		}
+	}
+
+	log("First run %s: %d of %d videos skipped", m.firstRunPolicy, len(skips), len(videos))
+
+	return nil
+}
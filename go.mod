@@ -3,7 +3,10 @@ module src.iriy.de/yttgchan
 go 1.16
 
 require (
-	github.com/joho/godotenv v1.3.0 // indirect
-	github.com/kkdai/youtube/v2 v2.7.0 // indirect
+	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
+	github.com/joho/godotenv v1.3.0
+	github.com/kkdai/youtube/v2 v2.7.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/net v0.0.0-20210521195947-fe42d452be8f // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
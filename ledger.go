@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// LedgerKey keeps the whole ledger of a mirror as one json object, so it is read in one call
+	// and a store like heroku does not get a config var and a release per video
+	LedgerKey = "YtLedger"
+	// LedgerPrefix is of the entries kept one per key before, they are moved into LedgerKey on the first read
+	LedgerPrefix = "YtVideo."
+
+	// HerokuLedgerMax leaves room for the other config vars in the 32kb heroku allows for all of them,
+	// the ledger keeps only the videos in flight or failed so it stays far below
+	HerokuLedgerMax = 16 << 10
+
+	VideoPending = "pending"
+	VideoPosted  = "posted"
+	VideoFailed  = "failed"
+	VideoSkipped = "skipped"
+)
+
+// LedgerEntry records the delivery of one youtube video to telegram.
+// The videos up to YtLast without an entry are done, the posted and skipped entries up to it are pruned.
+type LedgerEntry struct {
+	VideoId   string `json:"videoId"`
+	AudioName string `json:"audioName"`
+	Title     string `json:"title"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	Attempts  int    `json:"attempts"`
+
+	// AudioFormat is the stream the audio was made of
+	AudioFormat string `json:"audioFormat,omitempty"`
+	// VideoFormat are the streams the video was made of, in the video mode
+	VideoFormat string `json:"videoFormat,omitempty"`
+
+	// Backfill entries are kept when posted, as backfill posts the videos up to YtLast it has no entries of
+	Backfill bool `json:"backfill,omitempty"`
+
+	FirstSeen   time.Time  `json:"firstSeen"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	PostedAt    *time.Time `json:"postedAt,omitempty"`
+
+	TgPhotoMessageId   int64 `json:"tgPhotoMessageId,omitempty"`
+	TgAudioMessageId   int64 `json:"tgAudioMessageId,omitempty"`
+	TgVideoMessageId   int64 `json:"tgVideoMessageId,omitempty"`
+	TgMessageMessageId int64 `json:"tgMessageMessageId,omitempty"`
+}
+
+// Ledger maps the video ids to their entries.
+type Ledger map[string]*LedgerEntry
+
+// loadLedger reads the ledger once, it is kept until resetLedger which withLock calls before a run.
+func (m *Mirror) loadLedger() (Ledger, error) {
+	if m.ledger != nil {
+		return m.ledger, nil
+	}
+
+	ledger := make(Ledger)
+	v, err := State.Get(m.key(LedgerKey))
+	if err != nil {
+		return nil, err
+	}
+	if v != "" {
+		if err = json.Unmarshal([]byte(v), &ledger); err != nil {
+			return nil, fmt.Errorf("Unmarshal %s: %v", LedgerKey, err)
+		}
+	}
+
+	if err = m.migrateLedger(ledger); err != nil {
+		return nil, fmt.Errorf("migrate ledger: %v", err)
+	}
+
+	m.ledger = ledger
+	return ledger, nil
+}
+
+// migrateLedger moves the entries kept one per key into the ledger, a dry run only reads them.
+func (m *Mirror) migrateLedger(ledger Ledger) error {
+	prefix := m.key(LedgerPrefix)
+	keys, err := State.Keys(prefix)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	for _, k := range keys {
+		v, err := State.Get(k)
+		if err != nil {
+			return err
+		}
+		var e LedgerEntry
+		if err = json.Unmarshal([]byte(v), &e); err != nil {
+			return fmt.Errorf("Unmarshal %s: %v", k, err)
+		}
+		if _, ok := ledger[e.VideoId]; !ok {
+			ledger[e.VideoId] = &e
+		}
+	}
+	if *FlagDryRun {
+		return nil
+	}
+	if err = m.saveLedger(ledger); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = State.Delete(k); err != nil {
+			return err
+		}
+	}
+	log("Ledger: moved %d entries into %s", len(keys), m.key(LedgerKey))
+	return nil
+}
+
+// saveLedger writes the ledger without the posted and skipped entries up to YtLast.
+func (m *Mirror) saveLedger(ledger Ledger) error {
+	for ytid, e := range ledger {
+		if (e.Status == VideoPosted || e.Status == VideoSkipped) && !e.Backfill && m.Last != "" && e.AudioName <= m.Last {
+			delete(ledger, ytid)
+		}
+	}
+	b, err := json.Marshal(ledger)
+	if err != nil {
+		return err
+	}
+	if _, ok := State.(*HerokuStateStore); ok && len(b) > HerokuLedgerMax {
+		return fmt.Errorf("ledger is %dkb, too big for the heroku config vars, use StateStore bolt or json", len(b)>>10)
+	}
+	return State.Set(m.key(LedgerKey), string(b))
+}
+
+func (m *Mirror) resetLedger() {
+	m.ledger = nil
+}
+
+// ledgerEmpty reports whether the mirror has no ledger entries yet.
+func (m *Mirror) ledgerEmpty() (bool, error) {
+	ledger, err := m.loadLedger()
+	if err != nil {
+		return false, err
+	}
+	return len(ledger) == 0, nil
+}
+
+func (m *Mirror) LedgerGet(ytid string) (*LedgerEntry, error) {
+	ledger, err := m.loadLedger()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := ledger[ytid]
+	if !ok {
+		return nil, nil
+	}
+	// a copy, so changes are kept only by LedgerPut
+	entry := *e
+	return &entry, nil
+}
+
+// ledgerEntry returns the ledger entry of the video, a new posted one up to YtLast, or a new pending one.
+func (m *Mirror) ledgerEntry(vid YtPlaylistItemSnippet) (*LedgerEntry, error) {
+	ytid := vid.ResourceId.VideoId
+	entry, err := m.LedgerGet(ytid)
+	if err != nil {
+		return nil, fmt.Errorf("LedgerGet %s: %v", ytid, err)
+	}
+	if entry != nil {
+		return entry, nil
+	}
+	entry = newLedgerEntry(vid)
+	if m.Last != "" && entry.AudioName <= m.Last {
+		entry.Status = VideoPosted
+		entry.Reason = "up to YtLast"
+	}
+	return entry, nil
+}
+
+func newLedgerEntry(vid YtPlaylistItemSnippet) *LedgerEntry {
+	return &LedgerEntry{
+		VideoId:   vid.ResourceId.VideoId,
+		AudioName: videoAudioName(vid),
+		Title:     vid.Title,
+		Status:    VideoPending,
+		FirstSeen: time.Now().UTC(),
+	}
+}
+
+// Due reports whether the video still has to be posted.
+func (e *LedgerEntry) Due() bool {
+	switch e.Status {
+	case VideoPosted, VideoSkipped:
+		return false
+	case VideoFailed:
+		return e.Attempts < MaxAttempts
+	}
+	return true
+}
+
+func (m *Mirror) LedgerPut(e *LedgerEntry) error {
+	return m.LedgerPutAll([]*LedgerEntry{e})
+}
+
+// LedgerPutAll writes the entries in one state store call.
+func (m *Mirror) LedgerPutAll(entries []*LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ledger, err := m.loadLedger()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		entry := *e
+		ledger[e.VideoId] = &entry
+	}
+	if err = m.saveLedger(ledger); err != nil {
+		// read again on the next call, the failed changes are not kept
+		m.resetLedger()
+		return err
+	}
+	return nil
+}
+
+func (m *Mirror) LedgerList() (entries []*LedgerEntry, err error) {
+	ledger, err := m.loadLedger()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range ledger {
+		entry := *e
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].AudioName < entries[j].AudioName })
+	return entries, nil
+}
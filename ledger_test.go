@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testLedgerIds(t *testing.T, m *Mirror) (ids []string) {
+	m.resetLedger()
+	entries, err := m.LedgerList()
+	if err != nil {
+		t.Fatalf("LedgerList: %v", err)
+	}
+	for _, e := range entries {
+		ids = append(ids, e.VideoId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestLedgerWatermark(t *testing.T) {
+	testEnv(t, nil)
+	m := &Mirror{Name: "a", Last: videoAudioName(testVideo(5))}
+
+	var entries []*LedgerEntry
+	for n, status := range map[int]string{2: VideoPosted, 3: VideoPosted, 4: VideoFailed, 5: VideoSkipped, 6: VideoSkipped, 7: VideoPosted} {
+		e := newLedgerEntry(testVideo(n))
+		e.Status = status
+		e.Backfill = n == 2
+		entries = append(entries, e)
+	}
+	if err := m.LedgerPutAll(entries); err != nil {
+		t.Fatalf("LedgerPutAll: %v", err)
+	}
+
+	// the posted and skipped entries up to YtLast are pruned, unless backfilled
+	want := []string{testVideoId(2), testVideoId(4), testVideoId(6), testVideoId(7)}
+	if ids := testLedgerIds(t, m); !reflect.DeepEqual(ids, want) {
+		t.Errorf("ledger %v, want %v", ids, want)
+	}
+
+	v, err := State.Get(m.key(LedgerKey))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if strings.Contains(v, "0001-01-01") {
+		t.Errorf("ledger has zero times: %s", v)
+	}
+
+	for n, due := range map[int]bool{1: false, 3: false, 4: true, 5: false, 6: false, 8: true} {
+		e, err := m.ledgerEntry(testVideo(n))
+		if err != nil {
+			t.Fatalf("ledgerEntry: %v", err)
+		}
+		if e.Due() != due {
+			t.Errorf("video %d %s due %v, want %v", n, e.Status, e.Due(), due)
+		}
+	}
+
+	// moving the watermark back makes the entries after it pending and the videos without entries due again
+	if err = m.setLast(videoAudioName(testVideo(3))); err != nil {
+		t.Fatalf("setLast: %v", err)
+	}
+	for n, due := range map[int]bool{2: false, 3: false, 4: true, 5: true, 6: true, 7: true} {
+		e, err := m.ledgerEntry(testVideo(n))
+		if err != nil {
+			t.Fatalf("ledgerEntry: %v", err)
+		}
+		if e.Due() != due {
+			t.Errorf("after set-last video %d %s due %v, want %v", n, e.Status, e.Due(), due)
+		}
+	}
+
+	// and forward marks them done, pruning them
+	if err = m.setLast(videoAudioName(testVideo(8))); err != nil {
+		t.Fatalf("setLast: %v", err)
+	}
+	if ids := testLedgerIds(t, m); !reflect.DeepEqual(ids, []string{testVideoId(2)}) {
+		t.Errorf("ledger %v, want only the backfilled entry", ids)
+	}
+}
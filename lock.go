@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Lock is held by a run while it processes a mirror.
+type Lock interface {
+	Renew() error
+	Unlock() error
+}
+
+// Locker acquires the lock of a mirror, failing with a LockedError if another run holds it.
+type Locker interface {
+	Acquire(name string) (Lock, error)
+}
+
+type LockedError struct {
+	Name    string
+	Owner   string
+	Expires time.Time
+}
+
+func (err *LockedError) Error() string {
+	if err.Owner == "" {
+		return fmt.Sprintf("%s is locked by another run", err.Name)
+	}
+	return fmt.Sprintf("%s is locked by %s until %s", err.Name, err.Owner, err.Expires.Format(time.RFC3339))
+}
+
+var (
+	LockKind = "state"
+	LockDir  = "."
+	LockTtl  = 10 * time.Minute
+
+	LockOwner string
+	Locks     Locker
+)
+
+func NewLocker(kind string) (Locker, error) {
+	switch kind {
+	case "state", "":
+		return &StateLocker{Owner: LockOwner, Ttl: LockTtl}, nil
+	case "file":
+		return &FileLocker{Dir: LockDir}, nil
+	case "none":
+		return &NoLocker{}, nil
+	}
+	return nil, fmt.Errorf("unknown lock kind %#v", kind)
+}
+
+func lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d:%04x", hostname, os.Getpid(), rand.New(rand.NewSource(time.Now().UnixNano())).Intn(0x10000))
+}
+
+// withLock runs fn holding the mirror lock and renewing it in the background,
+// a LockedError is returned as is when another run holds the lock.
+// When a renewal fails, another run may take the lock, so Ctx is cancelled for fn to stop before posting anything more.
+func (m *Mirror) withLock(fn func() error) error {
+	lock, err := Locks.Acquire(m.key("YtLock"))
+	var lockedErr *LockedError
+	if errors.As(err, &lockedErr) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("lock: %v", err)
+	}
+
+	// the ledger may have been changed by another run while the lock was not held
+	m.resetLedger()
+
+	parentCtx := Ctx
+	var cancel context.CancelFunc
+	Ctx, cancel = context.WithCancel(parentCtx)
+
+	done := make(chan struct{})
+	var renewErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(LockTtl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if renewErr = lock.Renew(); renewErr != nil {
+					log("ERROR: lock renew: %v, stopping the run", renewErr)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err = fn()
+
+	close(done)
+	wg.Wait()
+	cancel()
+	Ctx = parentCtx
+	if uerr := lock.Unlock(); uerr != nil {
+		log("ERROR: unlock: %v", uerr)
+	}
+
+	if renewErr != nil {
+		return fmt.Errorf("lock renew: %v", renewErr)
+	}
+	return err
+}
+
+// StateLease is the lock record kept in the state store.
+type StateLease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// StateLocker keeps leases with an expiry in the state store, so runs on different hosts exclude each other
+// and a crashed run does not hold its mirror for longer than the lease ttl.
+type StateLocker struct {
+	Owner string
+	Ttl   time.Duration
+}
+
+type stateLock struct {
+	locker *StateLocker
+	key    string
+}
+
+func (l *StateLocker) read(key string) (*StateLease, error) {
+	v, err := State.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		return nil, nil
+	}
+	var lease StateLease
+	if err = json.Unmarshal([]byte(v), &lease); err != nil {
+		return nil, fmt.Errorf("Unmarshal %s: %v", key, err)
+	}
+	return &lease, nil
+}
+
+func (l *StateLocker) write(key string) error {
+	b, err := json.Marshal(StateLease{Owner: l.Owner, Expires: time.Now().UTC().Add(l.Ttl)})
+	if err != nil {
+		return err
+	}
+	return State.Set(key, string(b))
+}
+
+func (l *StateLocker) Acquire(key string) (Lock, error) {
+	lease, err := l.read(key)
+	if err != nil {
+		return nil, err
+	}
+	if lease != nil && lease.Owner != l.Owner && time.Now().Before(lease.Expires) {
+		return nil, &LockedError{Name: key, Owner: lease.Owner, Expires: lease.Expires}
+	}
+	if lease != nil && lease.Owner != l.Owner {
+		log("Lease %s of %s expired at %s, taking it over", key, lease.Owner, lease.Expires.Format(time.RFC3339))
+	}
+
+	if err = l.write(key); err != nil {
+		return nil, err
+	}
+
+	// the store has no compare and swap, read back to lose a race with a simultaneous run
+	lease, err = l.read(key)
+	if err != nil {
+		return nil, err
+	}
+	if lease == nil || lease.Owner != l.Owner {
+		var owner string
+		var expires time.Time
+		if lease != nil {
+			owner, expires = lease.Owner, lease.Expires
+		}
+		return nil, &LockedError{Name: key, Owner: owner, Expires: expires}
+	}
+
+	return &stateLock{locker: l, key: key}, nil
+}
+
+func (sl *stateLock) Renew() error {
+	lease, err := sl.locker.read(sl.key)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Owner != sl.locker.Owner {
+		return fmt.Errorf("lease %s lost", sl.key)
+	}
+	return sl.locker.write(sl.key)
+}
+
+func (sl *stateLock) Unlock() error {
+	lease, err := sl.locker.read(sl.key)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Owner != sl.locker.Owner {
+		return nil
+	}
+	return State.Delete(sl.key)
+}
+
+// FileLocker flocks a file per mirror, it only excludes runs on the same host.
+type FileLocker struct {
+	Dir string
+}
+
+func (l *FileLocker) Acquire(name string) (Lock, error) {
+	path := filepath.Join(l.Dir, name+".lock")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = flock(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(LockOwner+"\n"), 0)
+	}
+	if err != nil {
+		funlock(f)
+		f.Close()
+		return nil, err
+	}
+	return &fileLock{f: f}, nil
+}
+
+type fileLock struct {
+	f *os.File
+}
+
+func (fl *fileLock) Renew() error {
+	return nil
+}
+
+func (fl *fileLock) Unlock() error {
+	if err := funlock(fl.f); err != nil {
+		fl.f.Close()
+		return err
+	}
+	return fl.f.Close()
+}
+
+// NoLocker does not lock at all, for setups where a single run is guaranteed.
+type NoLocker struct{}
+
+type noLock struct{}
+
+func (NoLocker) Acquire(name string) (Lock, error) {
+	return noLock{}, nil
+}
+
+func (noLock) Renew() error {
+	return nil
+}
+
+func (noLock) Unlock() error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func flock(f *os.File) error {
+	return fmt.Errorf("file locks are not supported on %s, use LockKind state", runtime.GOOS)
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
+
+// flockWait does not lock, the state files are only guarded within the process here.
+func flockWait(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Run posts to telegram every listed video of the mirror that is not posted yet.
+func (m *Mirror) Run() error {
+	Quota.Begin(m)
+	defer Quota.Summary()
+
+	err := m.withLock(m.run)
+	var lockedErr *LockedError
+	if errors.As(err, &lockedErr) {
+		log("Skipping mirror %s: %v", m.Name, err)
+		return nil
+	}
+	if quotaStop(err) {
+		log("WARNING: Skipping mirror %s: %v", m.Name, err)
+		return nil
+	}
+	return err
+}
+
+func (m *Mirror) run() error {
+	videos, err := m.ListVideos()
+	if err != nil {
+		return err
+	}
+
+	log("Videos: %d", len(videos))
+
+	err = m.LedgerFirstRun(videos)
+	if err != nil {
+		return fmt.Errorf("LedgerFirstRun: %v", err)
+	}
+
+	err = m.enrichVideos(videos)
+	if quotaStop(err) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("enrichVideos: %v", err)
+	}
+
+	held, err := m.LedgerFilter(videos)
+	if quotaStop(err) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("LedgerFilter: %v", err)
+	}
+
+	for vidnum, vid := range videos {
+		if stopping() {
+			log("Stopping before #%d", vidnum+1)
+			return nil
+		}
+
+		entry, err := m.ledgerEntry(vid)
+		if err != nil {
+			return err
+		}
+		if !entry.Due() {
+			continue
+		}
+
+		if waiting := vid.Details.Waiting(); waiting != "" {
+			if err = m.deferVideo(vidnum, entry, waiting); err != nil {
+				return err
+			}
+			continue
+		}
+		// held back videos get an entry, so a later video moving YtLast past them does not make them done
+		if held[vid.ResourceId.VideoId] {
+			if err = m.deferVideo(vidnum, entry, "filter undecided"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = m.processVideo(vidnum, vid, entry)
+		if err != nil && Ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processVideo posts one video and records the outcome in the ledger,
+// a failed post is recorded and only state store errors are returned.
+func (m *Mirror) processVideo(vidnum int, vid YtPlaylistItemSnippet, entry *LedgerEntry) error {
+	ytid := vid.ResourceId.VideoId
+	audioName := entry.AudioName
+	title := m.cleanTitle(vid.Title)
+
+	log("New: #%d %s: %s", vidnum+1, audioName, title)
+
+	now := time.Now().UTC()
+	entry.Attempts++
+	entry.LastAttempt = &now
+
+	err := m.postVideo(vid, title, entry)
+	if err != nil && Ctx.Err() != nil {
+		log("#%d %s: aborted: %v", vidnum+1, audioName, err)
+		return Ctx.Err()
+	}
+	// without the details an upcoming video is only told by the download error
+	var waitingErr *VideoWaitingError
+	if errors.As(err, &waitingErr) {
+		entry.Attempts--
+		return m.deferVideo(vidnum, entry, waitingErr.Reason)
+	}
+	var unavailableErr *VideoUnavailableError
+	if errors.As(err, &unavailableErr) && m.unavailablePolicy[unavailableErr.Kind] == PolicySkip {
+		log("#%d %s: skipped: %v", vidnum+1, audioName, err)
+		entry.Status = VideoSkipped
+		entry.Reason = err.Error()
+		if err := m.LedgerPut(entry); err != nil {
+			return fmt.Errorf("LedgerPut %s: %v", ytid, err)
+		}
+		return nil
+	}
+	if err != nil {
+		log("#%d %s: %v", vidnum+1, audioName, err)
+		entry.Status = VideoFailed
+		entry.Reason = err.Error()
+		if err := m.LedgerPut(entry); err != nil {
+			return fmt.Errorf("LedgerPut %s: %v", ytid, err)
+		}
+		return nil
+	}
+
+	entry.Status = VideoPosted
+	entry.Reason = ""
+	postedAt := time.Now().UTC()
+	entry.PostedAt = &postedAt
+	err = m.LedgerPut(entry)
+	if err != nil {
+		return fmt.Errorf("LedgerPut %s: %v", ytid, err)
+	}
+
+	if audioName > m.Last {
+		m.Last = audioName
+		err = State.Set(m.key("YtLast"), audioName)
+		if err != nil {
+			return fmt.Errorf("State.Set YtLast: %v", err)
+		}
+	}
+
+	log("#%d uploaded", vidnum+1)
+
+	return nil
+}
+
+// PlaylistIds returns the mirror playlists, resolving the channel uploads playlist if none is configured.
+func (m *Mirror) PlaylistIds() (playlistIds []string, err error) {
+	sources, err := m.resolveSources()
+	if err != nil {
+		return nil, err
+	}
+	var sourceVideos int
+	playlistIds = append(playlistIds, m.YtPlaylistId...)
+	for _, r := range sources {
+		if r.PlaylistId != "" {
+			playlistIds = append(playlistIds, r.PlaylistId)
+		}
+		if r.VideoId != "" {
+			sourceVideos++
+		}
+	}
+
+	if len(playlistIds) == 0 && sourceVideos == 0 {
+		if m.YtUsername == "" && m.YtChannelId == "" {
+			return nil, fmt.Errorf("Empty YtPlaylistId and YtSources and YtUsername and YtChannelId, nothing to do")
+		}
+
+		ChannelListUrlValues := url.Values{}
+		ChannelListUrlValues.Set("part", "contentDetails")
+		if m.YtUsername != "" {
+			ChannelListUrlValues.Set("forUsername", m.YtUsername)
+		} else if m.YtChannelId != "" {
+			ChannelListUrlValues.Set("id", m.YtChannelId)
+		}
+		var userChannels YtChannelListResponse
+		err = ytGetJson("channels", ChannelListUrlValues, &userChannels)
+		if quotaStop(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get channels list: %v", err)
+		}
+
+		if len(userChannels.Items) == 0 {
+			return nil, fmt.Errorf("Empty channels list")
+		}
+		uploads := userChannels.Items[0].ContentDetails.RelatedPlaylists.Uploads
+		if uploads == "" {
+			return nil, fmt.Errorf("Empty playlist id was retrieved")
+		}
+		playlistIds = []string{uploads}
+	}
+
+	return playlistIds, nil
+}
+
+// ListVideos lists all the videos of the mirror playlists sorted by publishing time.
+func (m *Mirror) ListVideos() (videos []YtPlaylistItemSnippet, err error) {
+	if m.YtSource == "feed" {
+		return m.listFeed()
+	}
+	return m.listApi()
+}
+
+func (m *Mirror) listApi() (videos []YtPlaylistItemSnippet, err error) {
+	playlistIds, err := m.PlaylistIds()
+	if err != nil {
+		return nil, err
+	}
+
+	// uploads playlists are newest first, so paging can stop at a page of videos which are done,
+	// failed ones still due keep the paging going down to the oldest of them so they are retried
+	incremental := !m.YtFullScan && !*FlagFullScan
+	var oldestDue string
+	if incremental {
+		entries, err := m.LedgerList()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Due() {
+				oldestDue = e.AudioName
+				break
+			}
+		}
+	}
+
+	// a reached quota budget stops the listing, the videos listed so far are still mirrored
+	var budgetErr error
+	for _, plid := range playlistIds {
+		if plid == "" || budgetErr != nil {
+			continue
+		}
+		it := NewPlaylistIterator(plid)
+		for !it.Done() {
+			page, err := it.Next()
+			if quotaStop(err) {
+				budgetErr = err
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			allDone := len(page) > 0
+			for _, vid := range page {
+				videos = append(videos, vid)
+				entry, err := m.ledgerEntry(vid)
+				if err != nil {
+					return nil, err
+				}
+				if entry.Due() {
+					allDone = false
+				}
+			}
+
+			if allDone && oldestDue != "" && videoAudioName(page[len(page)-1]) >= oldestDue {
+				allDone = false
+			}
+			if incremental && strings.HasPrefix(plid, "UU") && allDone && !it.Done() {
+				log("Playlist %s: stopped at a page of videos which are done", plid)
+				break
+			}
+		}
+	}
+
+	if budgetErr == nil {
+		sourceVideos, err := m.sourceVideos()
+		if quotaStop(err) {
+			budgetErr = err
+		} else if err != nil {
+			return nil, err
+		}
+		videos = append(videos, sourceVideos...)
+	}
+
+	if budgetErr != nil {
+		// the first run policy needs the whole listing
+		first, err := m.firstRun()
+		if err != nil {
+			return nil, err
+		}
+		if len(videos) == 0 || first {
+			return nil, budgetErr
+		}
+		log("WARNING: %v, listing stopped at %d videos", budgetErr, len(videos))
+	}
+
+	return mergeVideos(videos), nil
+}
+
+func videoAudioName(vid YtPlaylistItemSnippet) string {
+	publishedAt := strings.NewReplacer("-", "", "T", ".", ":", "").Replace(vid.PublishedAt)
+	publishedAt = strings.TrimSuffix(publishedAt, "Z")
+	publishedAt = strings.TrimSuffix(publishedAt, ".000")
+
+	return fmt.Sprintf("%s.%s", publishedAt, vid.ResourceId.VideoId)
+}
+
+func (m *Mirror) cleanTitle(title string) string {
+	if m.titleCleanRe != nil {
+		title = m.titleCleanRe.ReplaceAllString(title, "")
+	}
+	if m.TgTitleUnquote {
+		if strings.HasPrefix(title, `"`) && strings.HasSuffix(title, `"`) {
+			title = strings.Trim(title, `"`)
+		}
+		if strings.HasPrefix(title, `«`) && strings.HasSuffix(title, `»`) {
+			title = strings.Trim(title, `«`)
+			title = strings.Trim(title, `»`)
+		}
+		for strings.Contains(title, `"`) {
+			title = strings.Replace(title, `"`, `«`, 1)
+			title = strings.Replace(title, `"`, `»`, 1)
+		}
+	}
+
+	return title
+}
+
+// videoCoverUrls picks the largest thumbnail for the cover and the medium one for the audio thumb,
+// falling back to the default thumbnails of the video when the listing has none.
+func videoCoverUrls(vid YtPlaylistItemSnippet) (coverUrl, thumbUrl string) {
+	coverUrl = vid.Thumbnails.MaxRes.Url
+	if coverUrl == "" {
+		coverUrl = vid.Thumbnails.Standard.Url
+	}
+	if coverUrl == "" {
+		coverUrl = vid.Thumbnails.High.Url
+	}
+	if coverUrl == "" {
+		coverUrl = vid.Thumbnails.Medium.Url
+	}
+	if coverUrl == "" {
+		coverUrl = YtThumbBaseUrl + vid.ResourceId.VideoId + "/hqdefault.jpg"
+	}
+
+	thumbUrl = vid.Thumbnails.Medium.Url
+	if thumbUrl == "" {
+		thumbUrl = YtThumbBaseUrl + vid.ResourceId.VideoId + "/mqdefault.jpg"
+	}
+
+	return coverUrl, thumbUrl
+}
+
+func (m *Mirror) postVideo(vid YtPlaylistItemSnippet, title string, entry *LedgerEntry) (err error) {
+	ytid := vid.ResourceId.VideoId
+	audioName := entry.AudioName
+	description := vid.Description
+
+	log("Description: %d letters", len([]rune(description)))
+
+	var coverBuf, thumbBuf *bytes.Buffer
+
+	if err = unavailableVideo(vid); err != nil {
+		return err
+	}
+
+	coverUrl, thumbUrl := videoCoverUrls(vid)
+
+	thumbBuf, err = downloadFile(thumbUrl)
+	if err != nil {
+		return &VideoUnavailableError{Kind: UnavailableNoThumbnails, Reason: fmt.Sprintf("Download thumb: %v", err)}
+	}
+	log(
+		"Thumb: %dkb",
+		thumbBuf.Len()/1000,
+	)
+
+	// the video has the cover as its thumbnail, there is no cover photo
+	if m.TgMode == TgModeVideo {
+		return m.postAsVideo(vid, title, entry, thumbBuf)
+	}
+
+	coverBuf, err = downloadFile(coverUrl)
+	if err != nil {
+		return &VideoUnavailableError{Kind: UnavailableNoThumbnails, Reason: fmt.Sprintf("Download cover: %v", err)}
+	}
+	log(
+		"Cover: %dkb",
+		coverBuf.Len()/1000,
+	)
+
+	// the audio goes through files, so memory use does not grow with the duration
+	audioSrcFile := fmt.Sprintf("%s.m4a", audioName)
+	defer removeFile(audioSrcFile)
+	download, err := m.downloadAudio(ytid, audioSrcFile)
+	if err != nil {
+		return err
+	}
+	entry.AudioFormat = download.Format
+
+	log(
+		"Downloaded audio by %s %s duration:%ds",
+		download.Downloader,
+		download.Stats,
+		int64(download.Duration.Seconds()),
+	)
+	if download.Stats.Size/1000/1000 < 1 {
+		return fmt.Errorf("Downloaded audio less than one megabyte, something is wrong")
+	}
+
+	audioFile := fmt.Sprintf("%s.%s.m4a", audioName, m.TgAudioBitrate)
+	defer removeFile(audioFile)
+	err = exec.Command(
+		FfmpegPath, "-v", "panic", "-y",
+		"-i", audioSrcFile, "-vn",
+		"-b:a", m.TgAudioBitrate, audioFile,
+	).Run()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v", err)
+	}
+	removeFile(audioSrcFile)
+
+	audioReader, err := os.Open(audioFile)
+	if err != nil {
+		return fmt.Errorf("Open %s: %v", audioFile, err)
+	}
+	defer audioReader.Close()
+	audioInfo, err := audioReader.Stat()
+	if err != nil {
+		return fmt.Errorf("Stat %s: %v", audioFile, err)
+	}
+
+	log(
+		"Final converted audio size:%dmb bitrate:%sbps",
+		audioInfo.Size()/1000/1000, m.TgAudioBitrate,
+	)
+
+	// nothing is posted yet, so this is the last point where an abort is clean
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			m.rollback(entry)
+		}
+	}()
+
+	tgcover, err := tgsendPhotoFile(m.TgChatId, audioName, coverBuf, title)
+	if err != nil {
+		return fmt.Errorf("tgsendPhotoFile: %v", err)
+	}
+	if tgcover.FileId == "" {
+		return fmt.Errorf("tgsendPhotoFile: file_id empty")
+	}
+
+	// a lost lock or an abort cancels Ctx, stop before the next send
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	tgaudio, err := tgsendAudioFile(
+		m.TgChatId,
+		m.TgPerformer,
+		title,
+		audioName,
+		audioReader,
+		thumbBuf,
+		download.Duration,
+	)
+	if err != nil {
+		return fmt.Errorf("tgsendAudioFile: %v", err)
+	}
+	if tgaudio.FileId == "" {
+		return fmt.Errorf("tgsendAudioFile: file_id empty")
+	}
+
+	caption, err := m.photoCaption(vid, title)
+	if err != nil {
+		return err
+	}
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	photoMsg, err := tgsendPhoto(m.TgChatId, tgcover.FileId, caption)
+	if err != nil {
+		return fmt.Errorf("tgsendPhoto: %v", err)
+	}
+	entry.TgPhotoMessageId = photoMsg.MessageId
+
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	audioMsg, err := tgsendAudio(m.TgChatId, tgaudio.FileId)
+	if err != nil {
+		return fmt.Errorf("tgsendAudio: %v", err)
+	}
+	entry.TgAudioMessageId = audioMsg.MessageId
+
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	messageMsg, err := tgsendMessage(m.TgChatId, description)
+	if err != nil {
+		return fmt.Errorf("tgsendMessage: %v", err)
+	}
+	entry.TgMessageMessageId = messageMsg.MessageId
+
+	return nil
+}
+
+// rollback deletes the messages of a partially posted video.
+func (m *Mirror) rollback(entry *LedgerEntry) {
+	for _, id := range []*int64{&entry.TgPhotoMessageId, &entry.TgAudioMessageId, &entry.TgVideoMessageId, &entry.TgMessageMessageId} {
+		if *id == 0 {
+			continue
+		}
+		if err := tgdeleteMessage(m.TgChatId, *id); err != nil {
+			log("Rollback tgdeleteMessage(%d): %v", *id, err)
+			continue
+		}
+		*id = 0
+	}
+}
+
+func removeFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log("Remove %s: %v", path, err)
+	}
+}
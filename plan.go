@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// PlanItem describes what a run would post for one video.
+type PlanItem struct {
+	Num       int    `json:"num"`
+	VideoId   string `json:"videoId"`
+	AudioName string `json:"audioName"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+
+	Title        string `json:"title"`
+	CleanTitle   string `json:"cleanTitle"`
+	PhotoCaption string `json:"photoCaption"`
+	Performer    string `json:"performer"`
+	Description  string `json:"description"`
+
+	Playlists []string `json:"playlists,omitempty"`
+
+	// Waiting is why the video is deferred, then nothing else is looked up
+	Waiting string `json:"waiting,omitempty"`
+
+	CoverUrl string `json:"coverUrl"`
+	ThumbUrl string `json:"thumbUrl"`
+
+	Format *PlanFormat `json:"format,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type PlanFormat struct {
+	Itag          int    `json:"itag"`
+	MimeType      string `json:"mimeType"`
+	Bitrate       int    `json:"bitrate"`
+	ContentLength int64  `json:"contentLength"`
+	Duration      int64  `json:"duration"`
+	AudioBitrate  string `json:"audioBitrate"`
+
+	// Height and AudioItag are set in the video mode, AudioItag when the video has no audio of its own
+	Height    int `json:"height,omitempty"`
+	AudioItag int `json:"audioItag,omitempty"`
+}
+
+type Plan struct {
+	Mirror string     `json:"mirror"`
+	ChatId string     `json:"chatId"`
+	Mode   string     `json:"mode"`
+	Videos int        `json:"videos"`
+	Items  []PlanItem `json:"items"`
+}
+
+// Plan lists what the next run would post without downloading the audio or calling telegram.
+func (m *Mirror) Plan() (*Plan, error) {
+	videos, err := m.ListVideos()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{Mirror: m.Name, ChatId: m.TgChatId, Mode: m.TgMode, Videos: len(videos)}
+
+	// the first run skips are not recorded in a dry run
+	first, err := m.firstRun()
+	if err != nil {
+		return nil, err
+	}
+	var skips map[string]bool
+	if first {
+		skips = m.firstRunSkips(videos)
+	}
+
+	if err = m.enrichVideos(videos); err != nil {
+		return nil, err
+	}
+
+	// nor the filtered ones
+	filtered, held, err := m.filterSkips(videos)
+	if err != nil {
+		return nil, err
+	}
+
+	for vidnum, vid := range videos {
+		if stopping() {
+			break
+		}
+
+		audioName := videoAudioName(vid)
+		if skips[vid.ResourceId.VideoId] {
+			continue
+		}
+		if _, ok := filtered[vid.ResourceId.VideoId]; ok {
+			continue
+		}
+
+		entry, err := m.ledgerEntry(vid)
+		if err != nil {
+			return nil, err
+		}
+		if !entry.Due() {
+			continue
+		}
+		waiting := vid.Details.Waiting()
+		if waiting == "" && held[vid.ResourceId.VideoId] {
+			continue
+		}
+
+		title := m.cleanTitle(vid.Title)
+		item := PlanItem{
+			Num:         vidnum + 1,
+			VideoId:     entry.VideoId,
+			AudioName:   audioName,
+			Status:      entry.Status,
+			Attempts:    entry.Attempts,
+			Title:       vid.Title,
+			CleanTitle:  title,
+			Performer:   m.TgPerformer,
+			Description: vid.Description,
+			Playlists:   vid.Playlists,
+			Waiting:     waiting,
+		}
+		if waiting != "" {
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+
+		item.PhotoCaption, err = m.photoCaption(vid, title)
+		if err != nil {
+			item.Error = err.Error()
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+
+		item.CoverUrl, item.ThumbUrl = videoCoverUrls(vid)
+
+		if err = unavailableVideo(vid); err != nil {
+			item.Error = err.Error()
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+
+		vinfo, err := YtCl.GetVideoContext(Ctx, entry.VideoId)
+		switch cerr := classifyYtError(err).(type) {
+		case *VideoWaitingError:
+			item.Waiting = cerr.Reason
+			plan.Items = append(plan.Items, item)
+			continue
+		case *VideoUnavailableError:
+			item.Error = cerr.Error()
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		if err != nil {
+			item.Error = fmt.Sprintf("GetVideoContext: %v", err)
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		var f yt.Format
+		var audio *yt.Format
+		if m.TgMode == TgModeVideo {
+			f, audio, err = m.selectVideoFormats(vinfo)
+		} else {
+			f, err = m.selectAudioFormat(vinfo)
+		}
+		if err != nil {
+			item.Error = err.Error()
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		item.Format = &PlanFormat{
+			Itag:          f.ItagNo,
+			MimeType:      f.MimeType,
+			Bitrate:       f.Bitrate,
+			ContentLength: f.ContentLength,
+			Duration:      int64(vinfo.Duration.Seconds()),
+			AudioBitrate:  m.TgAudioBitrate,
+		}
+		if m.TgMode == TgModeVideo {
+			item.Format.Height = f.Height
+		}
+		if audio != nil {
+			item.Format.AudioItag = audio.ItagNo
+			item.Format.ContentLength += audio.ContentLength
+		}
+
+		plan.Items = append(plan.Items, item)
+	}
+
+	return plan, nil
+}
+
+func printPlans(plans []*Plan, asJson bool) error {
+	if asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(plans)
+	}
+
+	for _, plan := range plans {
+		if plan.Mirror != "" {
+			fmt.Printf("Mirror: %s\n", plan.Mirror)
+		}
+		fmt.Printf("Chat: %s\n", plan.ChatId)
+		fmt.Printf("Mode: %s\n", plan.Mode)
+		fmt.Printf("Videos: %d, to post: %d\n", plan.Videos, len(plan.Items))
+		for _, item := range plan.Items {
+			fmt.Printf("\n#%d %s (%s, attempts %d)\n", item.Num, item.AudioName, item.Status, item.Attempts)
+			if item.Waiting != "" {
+				fmt.Printf("  Deferred: waiting: %s\n", item.Waiting)
+			}
+			fmt.Printf("  Title: %s\n", item.Title)
+			fmt.Printf("  Clean title: %s\n", item.CleanTitle)
+			fmt.Printf("  Photo caption: %s\n", item.PhotoCaption)
+			fmt.Printf("  Audio: %s - %s\n", item.Performer, item.CleanTitle)
+			if len(item.Playlists) > 0 {
+				fmt.Printf("  Playlists: %s\n", strings.Join(item.Playlists, " "))
+			}
+			fmt.Printf("  Cover: %s\n", item.CoverUrl)
+			fmt.Printf("  Thumb: %s\n", item.ThumbUrl)
+			if f := item.Format; f != nil && f.Height > 0 {
+				fmt.Printf(
+					"  Format: itag:%d %s %dp audio itag:%d size:%dmb duration:%ds\n",
+					f.Itag, f.MimeType, f.Height, f.AudioItag, f.ContentLength/1000/1000, f.Duration,
+				)
+			} else if f != nil {
+				fmt.Printf(
+					"  Format: itag:%d %s bitrate:%dkbps size:%dmb duration:%ds -> %sbps\n",
+					f.Itag, f.MimeType, f.Bitrate/1024, f.ContentLength/1000/1000, f.Duration, f.AudioBitrate,
+				)
+			}
+			if item.Error != "" {
+				fmt.Printf("  Error: %s\n", item.Error)
+			}
+			fmt.Printf("  Description: %d letters\n%s\n", len([]rune(item.Description)), item.Description)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// PlaylistIterator pages through one playlist with its own page token.
+type PlaylistIterator struct {
+	PlaylistId string
+
+	pageToken string
+	done      bool
+}
+
+func NewPlaylistIterator(playlistId string) *PlaylistIterator {
+	return &PlaylistIterator{PlaylistId: playlistId}
+}
+
+// Next returns the next page of the playlist items, an empty page after the last one.
+func (it *PlaylistIterator) Next() (videos []YtPlaylistItemSnippet, err error) {
+	if it.done {
+		return nil, nil
+	}
+
+	PlaylistItemsUrlValues := url.Values{}
+	PlaylistItemsUrlValues.Set("part", "snippet")
+	PlaylistItemsUrlValues.Set("maxResults", fmt.Sprintf("%d", YtMaxResults))
+	PlaylistItemsUrlValues.Set("playlistId", it.PlaylistId)
+	if it.pageToken != "" {
+		PlaylistItemsUrlValues.Set("pageToken", it.pageToken)
+	}
+
+	var playlistItems YtPlaylistItems
+	err = ytGetJson("playlistItems", PlaylistItemsUrlValues, &playlistItems)
+	if quotaStop(err) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get playlist %s items: %v", it.PlaylistId, err)
+	}
+
+	// a repeated page token would loop forever
+	if playlistItems.NextPageToken == "" || playlistItems.NextPageToken == it.pageToken {
+		it.done = true
+	}
+	it.pageToken = playlistItems.NextPageToken
+
+	for _, i := range playlistItems.Items {
+		i.Snippet.Playlists = []string{it.PlaylistId}
+		videos = append(videos, i.Snippet)
+	}
+	return videos, nil
+}
+
+// Done reports whether the last page was returned.
+func (it *PlaylistIterator) Done() bool {
+	return it.done
+}
+
+// mergeVideos de-duplicates the videos by id, keeping the playlists of all the copies,
+// and sorts them by publishing time.
+func mergeVideos(videos []YtPlaylistItemSnippet) (merged []YtPlaylistItemSnippet) {
+	index := make(map[string]int)
+	for _, vid := range videos {
+		ytid := vid.ResourceId.VideoId
+		i, ok := index[ytid]
+		if !ok {
+			index[ytid] = len(merged)
+			vid.Playlists = append([]string{}, vid.Playlists...)
+			merged = append(merged, vid)
+			continue
+		}
+		for _, plid := range vid.Playlists {
+			if !containsString(merged[i].Playlists, plid) {
+				merged[i].Playlists = append(merged[i].Playlists, plid)
+			}
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].PublishedAt < merged[j].PublishedAt })
+
+	return merged
+}
+
+func containsString(ss []string, s string) bool {
+	for _, e := range ss {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
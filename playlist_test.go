@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testPlaylist serves the playlist items api with pages of three videos, newest first,
+// the video n published at the hour n of 2021-01-01.
+type testPlaylist struct {
+	Pages int
+	// Loop repeats the page token of the last page
+	Loop bool
+
+	requests []string
+}
+
+func testVideoId(n int) string {
+	return fmt.Sprintf("video%06d", n)
+}
+
+func testVideo(n int) (vid YtPlaylistItemSnippet) {
+	vid.ResourceId.VideoId = testVideoId(n)
+	vid.PublishedAt = fmt.Sprintf("2021-01-01T%02d:00:00Z", n)
+	return vid
+}
+
+func (p *testPlaylist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if r.URL.Path != "/youtube/v3/playlistItems" || q.Get("key") != "testkey" || q.Get("part") != "snippet" {
+		http.Error(w, `{"error":{"code":400,"message":"bad request","errors":[{"reason":"badRequest"}]}}`, http.StatusBadRequest)
+		return
+	}
+	p.requests = append(p.requests, q.Get("pageToken"))
+
+	page := 0
+	if token := q.Get("pageToken"); token != "" {
+		page, _ = strconv.Atoi(token)
+	}
+
+	var items YtPlaylistItems
+	switch {
+	case page < p.Pages-1:
+		items.NextPageToken = strconv.Itoa(page + 1)
+	case p.Loop:
+		items.NextPageToken = q.Get("pageToken")
+	}
+	for i := 0; i < 3; i++ {
+		n := 3*p.Pages - 3*page - i
+		item := YtPlaylistItem{Snippet: testVideo(n)}
+		items.Items = append(items.Items, item)
+	}
+	json.NewEncoder(w).Encode(items)
+}
+
+func TestPlaylistIterator(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		playlist *testPlaylist
+		requests []string
+	}{
+		{"one page", &testPlaylist{Pages: 1}, []string{""}},
+		{"three pages", &testPlaylist{Pages: 3}, []string{"", "1", "2"}},
+		{"repeated token", &testPlaylist{Pages: 2, Loop: true}, []string{"", "1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testEnv(t, tc.playlist)
+
+			it := NewPlaylistIterator("PLlist")
+			var videos int
+			for !it.Done() {
+				page, err := it.Next()
+				if err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				for _, vid := range page {
+					if !reflect.DeepEqual(vid.Playlists, []string{"PLlist"}) {
+						t.Errorf("video %s playlists %v", vid.ResourceId.VideoId, vid.Playlists)
+					}
+				}
+				videos += len(page)
+			}
+			if page, err := it.Next(); page != nil || err != nil {
+				t.Errorf("Next after the last page: %v %v", page, err)
+			}
+
+			if !reflect.DeepEqual(tc.playlist.requests, tc.requests) {
+				t.Errorf("page tokens %#v, want %#v", tc.playlist.requests, tc.requests)
+			}
+			if videos != 3*len(tc.requests) {
+				t.Errorf("got %d videos, want %d", videos, 3*len(tc.requests))
+			}
+		})
+	}
+}
+
+func TestListApiEarlyStop(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		playlist string
+		fullScan bool
+		// done are the videos posted before, the playlist has the videos 1 to 12
+		done     []int
+		last     int
+		failed   int
+		requests []string
+	}{
+		{"empty ledger", "UUchannel", false, nil, 0, 0, []string{"", "1", "2", "3"}},
+		{"stops at a done page", "UUchannel", false, []int{4, 5, 6, 7, 8, 9}, 0, 0, []string{"", "1"}},
+		{"new video on the second page", "UUchannel", false, []int{4, 5, 6, 8, 9}, 0, 0, []string{"", "1", "2"}},
+		{"stops at the watermark", "UUchannel", false, []int{12}, 9, 0, []string{"", "1"}},
+		{"watermark without a ledger", "UUchannel", false, nil, 9, 0, []string{"", "1"}},
+		{"failed older than the watermark", "UUchannel", false, []int{12}, 9, 8, []string{"", "1", "2"}},
+		{"failed on a later page", "UUchannel", false, []int{12}, 9, 5, []string{"", "1", "2", "3"}},
+		{"failed on the first page", "UUchannel", false, []int{12}, 9, 11, []string{"", "1"}},
+		{"full scan", "UUchannel", true, []int{4, 5, 6, 7, 8, 9}, 0, 0, []string{"", "1", "2", "3"}},
+		{"not an uploads playlist", "PLlist", false, []int{4, 5, 6, 7, 8, 9}, 0, 0, []string{"", "1", "2", "3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			playlist := &testPlaylist{Pages: 4}
+			testEnv(t, playlist)
+
+			m := &Mirror{YtPlaylistId: []string{tc.playlist}, YtFullScan: tc.fullScan}
+			var entries []*LedgerEntry
+			for _, n := range tc.done {
+				entry := newLedgerEntry(testVideo(n))
+				entry.Status = VideoPosted
+				entries = append(entries, entry)
+			}
+			if tc.failed > 0 {
+				entry := newLedgerEntry(testVideo(tc.failed))
+				entry.Status = VideoFailed
+				entry.Attempts = 1
+				entries = append(entries, entry)
+			}
+			if err := m.LedgerPutAll(entries); err != nil {
+				t.Fatalf("LedgerPutAll: %v", err)
+			}
+			if tc.last > 0 {
+				m.Last = videoAudioName(testVideo(tc.last))
+			}
+
+			videos, err := m.listApi()
+			if err != nil {
+				t.Fatalf("listApi: %v", err)
+			}
+
+			if !reflect.DeepEqual(playlist.requests, tc.requests) {
+				t.Errorf("page tokens %#v, want %#v", playlist.requests, tc.requests)
+			}
+			if len(videos) != 3*len(tc.requests) {
+				t.Fatalf("got %d videos, want %d", len(videos), 3*len(tc.requests))
+			}
+			for i := 1; i < len(videos); i++ {
+				if videos[i-1].PublishedAt > videos[i].PublishedAt {
+					t.Errorf("videos not sorted by publishing time at %d", i)
+				}
+			}
+		})
+	}
+}
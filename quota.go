@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// YtQuotaCosts are the data api quota units of a call by endpoint, the ones missing here cost one unit.
+var YtQuotaCosts = map[string]int{
+	"search": 100,
+}
+
+var (
+	// YtQuotaDayBudget is the units all the mirrors may use per day with one api key, 0 for no limit
+	YtQuotaDayBudget = 10000
+	// YtQuotaRunBudget is the units a mirror may use per run, 0 for no limit
+	YtQuotaRunBudget int
+
+	Quota = &QuotaMeter{}
+)
+
+// QuotaDay is the usage of a pacific time day, when the data api quota resets, kept in the state store.
+type QuotaDay struct {
+	Date      string         `json:"date"`
+	Units     int            `json:"units"`
+	Endpoints map[string]int `json:"endpoints"`
+	Mirrors   map[string]int `json:"mirrors"`
+	Keys      map[string]int `json:"keys"`
+}
+
+// QuotaMeter counts the units of the data api calls, for the current mirror run and for the day.
+type QuotaMeter struct {
+	mirror    string
+	runBudget int
+
+	run       map[string]int
+	runUnits  int
+	day       *QuotaDay
+	dayLoaded bool
+}
+
+type QuotaBudgetError struct {
+	Budget string
+	Units  int
+}
+
+func (err *QuotaBudgetError) Error() string {
+	return fmt.Sprintf("%s quota budget of %d units reached", err.Budget, err.Units)
+}
+
+var pacificTime = loadPacificTime()
+
+func loadPacificTime() *time.Location {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		return time.FixedZone("PST", -8*60*60)
+	}
+	return loc
+}
+
+// quotaDate is the date of the data api quota day.
+func quotaDate(t time.Time) string {
+	return t.In(pacificTime).Format("2006-01-02")
+}
+
+func (q *QuotaMeter) load() error {
+	date := quotaDate(time.Now())
+	if q.dayLoaded && q.day.Date == date {
+		return nil
+	}
+	q.day = &QuotaDay{Date: date, Endpoints: make(map[string]int), Mirrors: make(map[string]int), Keys: make(map[string]int)}
+	q.dayLoaded = true
+
+	v, err := State.Get("YtQuota")
+	if err != nil {
+		return err
+	}
+	if v == "" {
+		return nil
+	}
+	var day QuotaDay
+	if err = json.Unmarshal([]byte(v), &day); err != nil {
+		log("WARNING: YtQuota: %v", err)
+		return nil
+	}
+	if day.Date == date {
+		if day.Endpoints == nil {
+			day.Endpoints = make(map[string]int)
+		}
+		if day.Mirrors == nil {
+			day.Mirrors = make(map[string]int)
+		}
+		if day.Keys == nil {
+			day.Keys = make(map[string]int)
+		}
+		q.day = &day
+	}
+	return nil
+}
+
+// Save writes the day usage to the state store, not in a dry run.
+func (q *QuotaMeter) Save() error {
+	if !q.dayLoaded || *FlagDryRun {
+		return nil
+	}
+	b, err := json.Marshal(q.day)
+	if err != nil {
+		return err
+	}
+	return State.Set("YtQuota", string(b))
+}
+
+// Begin starts counting the run of a mirror.
+func (q *QuotaMeter) Begin(m *Mirror) {
+	q.mirror = m.Name
+	q.runBudget = m.YtQuotaRunBudget
+	q.run = make(map[string]int)
+	q.runUnits = 0
+}
+
+// Charge counts a call to the endpoint with the key, failing without counting if it would go over a budget.
+func (q *QuotaMeter) Charge(endpoint, key string) error {
+	if err := q.load(); err != nil {
+		return fmt.Errorf("quota: %v", err)
+	}
+	cost, ok := YtQuotaCosts[endpoint]
+	if !ok {
+		cost = 1
+	}
+	if q.runBudget > 0 && q.runUnits+cost > q.runBudget {
+		return &QuotaBudgetError{Budget: "run", Units: q.runBudget}
+	}
+	if YtQuotaDayBudget > 0 && q.day.Keys[key]+cost > YtQuotaDayBudget {
+		return &QuotaBudgetError{Budget: "day", Units: YtQuotaDayBudget}
+	}
+
+	if q.run == nil {
+		q.run = make(map[string]int)
+	}
+	q.run[endpoint] += cost
+	q.runUnits += cost
+	q.day.Units += cost
+	q.day.Endpoints[endpoint] += cost
+	q.day.Mirrors[q.mirror] += cost
+	q.day.Keys[key] += cost
+	return nil
+}
+
+// Today returns the units used today.
+func (q *QuotaMeter) Today() (units int, err error) {
+	if err = q.load(); err != nil {
+		return 0, err
+	}
+	return q.day.Units, nil
+}
+
+// Summary logs the units used by the run of the mirror and saves the day usage.
+func (q *QuotaMeter) Summary() {
+	if err := q.Save(); err != nil {
+		log("ERROR: quota save: %v", err)
+	}
+	if q.runUnits == 0 {
+		return
+	}
+
+	var endpoints []string
+	for e := range q.run {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	var byEndpoint []string
+	for _, e := range endpoints {
+		byEndpoint = append(byEndpoint, fmt.Sprintf("%s:%d", e, q.run[e]))
+	}
+
+	budget := "no budget"
+	if YtQuotaDayBudget > 0 {
+		budget = fmt.Sprintf("budget %d", YtQuotaDayBudget*len(YtKeys))
+	}
+	name := "Quota"
+	if q.mirror != "" {
+		name += " " + q.mirror
+	}
+	log(
+		"%s: %d units this run (%s), %d units today by this mirror, %d units today in total of %s",
+		name, q.runUnits, strings.Join(byEndpoint, " "), q.day.Mirrors[q.mirror], q.day.Units, budget,
+	)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+var YtWebBaseUrl = "https://www.youtube.com"
+
+// YtResolved is what a source identifier points to: a channel with its uploads playlist, a playlist or a single video.
+type YtResolved struct {
+	ChannelId  string `json:"channelId,omitempty"`
+	PlaylistId string `json:"playlistId,omitempty"`
+	VideoId    string `json:"videoId,omitempty"`
+}
+
+var (
+	ytChannelIdRe  = regexp.MustCompile(`^UC[0-9A-Za-z_-]{22}$`)
+	ytPlaylistIdRe = regexp.MustCompile(`^(PL|UU|OL|FL|LL|RD)[0-9A-Za-z_-]{10,}$`)
+	ytVideoIdRe    = regexp.MustCompile(`^[0-9A-Za-z_-]{11}$`)
+	ytLegacyNameRe = regexp.MustCompile(`^[0-9A-Za-z_.-]{3,100}$`)
+
+	ytPageChannelIdRe = regexp.MustCompile(`<link rel="canonical" href="https://www\.youtube\.com/channel/(UC[0-9A-Za-z_-]{22})"`)
+)
+
+// ytWebPaths are the site pages which are not legacy channel names.
+var ytWebPaths = map[string]bool{
+	"feed": true, "results": true, "playlist": true, "watch": true, "shorts": true, "live": true, "embed": true,
+	"channel": true, "c": true, "user": true, "hashtag": true, "gaming": true, "premium": true, "music": true,
+	"account": true, "signin": true, "logout": true, "upload": true, "about": true,
+}
+
+func uploadsPlaylistId(channelId string) string {
+	return "UU" + strings.TrimPrefix(channelId, "UC")
+}
+
+// parseYtSource resolves what can be resolved without network,
+// otherwise it returns the handle or the page url to look up.
+func parseYtSource(s string) (resolved YtResolved, handle, page string, err error) {
+	s = strings.TrimSpace(s)
+
+	switch {
+	case strings.HasPrefix(s, "@"):
+		return resolved, s, "", nil
+	case ytChannelIdRe.MatchString(s):
+		resolved.ChannelId = s
+		resolved.PlaylistId = uploadsPlaylistId(s)
+		return resolved, "", "", nil
+	case ytPlaylistIdRe.MatchString(s):
+		resolved.PlaylistId = s
+		return resolved, "", "", nil
+	case ytVideoIdRe.MatchString(s):
+		resolved.VideoId = s
+		return resolved, "", "", nil
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return resolved, "", "", fmt.Errorf("source %s: %v", s, err)
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Hostname(), "www."), "m.")
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch host {
+	case "youtu.be":
+		return ytVideoUrl(s, parts[0])
+	case "youtube.com", "music.youtube.com":
+		if list := u.Query().Get("list"); list != "" && (parts[0] == "playlist" || u.Query().Get("v") == "") {
+			resolved.PlaylistId = list
+			return resolved, "", "", nil
+		}
+		switch {
+		case parts[0] == "watch":
+			return ytVideoUrl(s, u.Query().Get("v"))
+		case parts[0] == "shorts" || parts[0] == "live" || parts[0] == "embed":
+			if len(parts) < 2 {
+				break
+			}
+			return ytVideoUrl(s, parts[1])
+		case parts[0] == "channel" && len(parts) > 1 && ytChannelIdRe.MatchString(parts[1]):
+			resolved.ChannelId = parts[1]
+			resolved.PlaylistId = uploadsPlaylistId(parts[1])
+			return resolved, "", "", nil
+		case strings.HasPrefix(parts[0], "@"):
+			return resolved, parts[0], "", nil
+		case (parts[0] == "c" || parts[0] == "user") && len(parts) > 1:
+			return resolved, "", YtWebBaseUrl + "/" + parts[0] + "/" + parts[1], nil
+		case len(parts) == 1 && ytLegacyNameRe.MatchString(parts[0]) && !ytWebPaths[parts[0]]:
+			// the legacy custom urls like youtube.com/name are not always the channel handles, the page tells the channel
+			return resolved, "", YtWebBaseUrl + "/" + parts[0], nil
+		}
+	}
+
+	return resolved, "", "", fmt.Errorf("source %s: not a channel, playlist or video", s)
+}
+
+// ytVideoUrl takes the id from a video url, the library extraction is only tried
+// on the video urls as it finds an id in almost any string.
+func ytVideoUrl(s, id string) (resolved YtResolved, handle, page string, err error) {
+	if !ytVideoIdRe.MatchString(id) {
+		id, err = yt.ExtractVideoID(s)
+		if err != nil || !ytVideoIdRe.MatchString(id) {
+			return resolved, "", "", fmt.Errorf("source %s: no video id in the url", s)
+		}
+	}
+	resolved.VideoId = id
+	return resolved, "", "", nil
+}
+
+// resolveHandle looks the channel up with the forHandle parameter, or on the channel page without YtKey.
+func resolveHandle(handle string) (resolved YtResolved, err error) {
+	if YtKey == "" {
+		return resolvePage(YtWebBaseUrl + "/" + handle)
+	}
+
+	ChannelListUrlValues := url.Values{}
+	ChannelListUrlValues.Set("part", "contentDetails")
+	ChannelListUrlValues.Set("forHandle", handle)
+	var channels YtChannelListResponse
+	err = ytGetJson("channels", ChannelListUrlValues, &channels)
+	if quotaStop(err) {
+		return resolved, err
+	}
+	if err != nil {
+		return resolved, fmt.Errorf("Failed to get channels list: %v", err)
+	}
+	if len(channels.Items) == 0 {
+		return resolved, fmt.Errorf("channel %s not found", handle)
+	}
+
+	resolved.ChannelId = channels.Items[0].Id
+	resolved.PlaylistId = channels.Items[0].ContentDetails.RelatedPlaylists.Uploads
+	if resolved.PlaylistId == "" {
+		resolved.PlaylistId = uploadsPlaylistId(resolved.ChannelId)
+	}
+	return resolved, nil
+}
+
+// resolvePage finds the channel id on a channel page, for custom urls the data api can not look up.
+func resolvePage(page string) (resolved YtResolved, err error) {
+	resp, err := HttpClient.Get(page)
+	if err != nil {
+		return resolved, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resolved, fmt.Errorf("%s: response status: %s", page, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resolved, fmt.Errorf("%s: %v", page, err)
+	}
+
+	match := ytPageChannelIdRe.FindSubmatch(body)
+	if match == nil {
+		return resolved, fmt.Errorf("%s: channel id not found on the page", page)
+	}
+
+	resolved.ChannelId = string(match[1])
+	resolved.PlaylistId = uploadsPlaylistId(resolved.ChannelId)
+	return resolved, nil
+}
+
+// resolveSources resolves the mirror YtSources, looked up ones are cached in the state store.
+func (m *Mirror) resolveSources() (sources []YtResolved, err error) {
+	if m.resolved != nil || len(m.YtSources) == 0 {
+		return m.resolved, nil
+	}
+
+	cacheKey := m.key("YtResolved")
+	cache := make(map[string]YtResolved)
+	if v, err := State.Get(cacheKey); err != nil {
+		return nil, err
+	} else if v != "" {
+		if err = json.Unmarshal([]byte(v), &cache); err != nil {
+			log("WARNING: %s: %v", cacheKey, err)
+			cache = make(map[string]YtResolved)
+		}
+	}
+
+	var cacheChanged bool
+	for _, s := range m.YtSources {
+		if r, ok := cache[s]; ok {
+			sources = append(sources, r)
+			continue
+		}
+
+		r, handle, page, err := parseYtSource(s)
+		if err != nil {
+			return nil, err
+		}
+		if handle != "" {
+			r, err = resolveHandle(handle)
+		} else if page != "" {
+			r, err = resolvePage(page)
+		}
+		if quotaStop(err) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolve %s: %v", s, err)
+		}
+		if handle != "" || page != "" {
+			log("Resolved %s: channel %s playlist %s", s, r.ChannelId, r.PlaylistId)
+			cache[s] = r
+			cacheChanged = true
+		}
+		sources = append(sources, r)
+	}
+
+	if cacheChanged && !*FlagDryRun {
+		b, err := json.Marshal(cache)
+		if err != nil {
+			return nil, err
+		}
+		if err = State.Set(cacheKey, string(b)); err != nil {
+			return nil, fmt.Errorf("State.Set %s: %v", cacheKey, err)
+		}
+	}
+
+	m.resolved = sources
+	return sources, nil
+}
+
+// sourceVideos gets the single videos of the mirror YtSources.
+func (m *Mirror) sourceVideos() (videos []YtPlaylistItemSnippet, err error) {
+	sources, err := m.resolveSources()
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, r := range sources {
+		if r.VideoId != "" {
+			ids = append(ids, r.VideoId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if YtKey == "" {
+		return nil, fmt.Errorf("single video sources need YtKey")
+	}
+
+	ytvideos, err := ytVideos(ids, YtVideoParts)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range ytvideos {
+		vid, err := v.item()
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, vid)
+	}
+	return videos, nil
+}
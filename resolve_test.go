@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestParseYtSource(t *testing.T) {
+	const (
+		channelId = "UCBJycsmduvYEL83R_U4JriQ"
+		uploadsId = "UUBJycsmduvYEL83R_U4JriQ"
+		videoId   = "dQw4w9WgXcQ"
+	)
+	for _, tc := range []struct {
+		source   string
+		resolved YtResolved
+		handle   string
+		page     string
+		err      bool
+	}{
+		{source: channelId, resolved: YtResolved{ChannelId: channelId, PlaylistId: uploadsId}},
+		{source: "PLrAXtmErZgOeiKm4sgNOknGvNjby9efdf", resolved: YtResolved{PlaylistId: "PLrAXtmErZgOeiKm4sgNOknGvNjby9efdf"}},
+		{source: videoId, resolved: YtResolved{VideoId: videoId}},
+		{source: " @mkbhd ", handle: "@mkbhd"},
+
+		{source: "https://www.youtube.com/channel/" + channelId, resolved: YtResolved{ChannelId: channelId, PlaylistId: uploadsId}},
+		{source: "youtube.com/channel/" + channelId + "/videos", resolved: YtResolved{ChannelId: channelId, PlaylistId: uploadsId}},
+		{source: "https://www.youtube.com/@mkbhd/videos", handle: "@mkbhd"},
+		{source: "https://www.youtube.com/c/mkbhd", page: "https://www.youtube.com/c/mkbhd"},
+		{source: "https://www.youtube.com/user/marquesbrownlee", page: "https://www.youtube.com/user/marquesbrownlee"},
+		{source: "https://www.youtube.com/mkbhd", page: "https://www.youtube.com/mkbhd"},
+		{source: "https://www.youtube.com/playlist?list=PLlist0123456789", resolved: YtResolved{PlaylistId: "PLlist0123456789"}},
+		{source: "https://music.youtube.com/playlist?list=OLAK5uy_abcdefghij", resolved: YtResolved{PlaylistId: "OLAK5uy_abcdefghij"}},
+
+		{source: "https://www.youtube.com/watch?v=" + videoId, resolved: YtResolved{VideoId: videoId}},
+		{source: "https://www.youtube.com/watch?v=" + videoId + "&list=PLlist0123456789", resolved: YtResolved{VideoId: videoId}},
+		{source: "https://m.youtube.com/watch?feature=share&v=" + videoId, resolved: YtResolved{VideoId: videoId}},
+		{source: "https://youtu.be/" + videoId + "?t=42", resolved: YtResolved{VideoId: videoId}},
+		{source: "https://www.youtube.com/shorts/" + videoId, resolved: YtResolved{VideoId: videoId}},
+		{source: "https://www.youtube.com/live/" + videoId + "?si=abc", resolved: YtResolved{VideoId: videoId}},
+		{source: "https://www.youtube.com/embed/" + videoId, resolved: YtResolved{VideoId: videoId}},
+
+		{source: "https://www.youtube.com/", err: true},
+		{source: "https://www.youtube.com/feed/subscriptions", err: true},
+		{source: "https://www.youtube.com/results?search_query=music", err: true},
+		{source: "https://www.youtube.com/channel/UCshort", err: true},
+		{source: "https://www.youtube.com/watch?v=short", err: true},
+		{source: "https://www.youtube.com/shorts/", err: true},
+		{source: "https://youtu.be/", err: true},
+		{source: "https://example.com/some/page", err: true},
+		{source: "example.com", err: true},
+	} {
+		resolved, handle, page, err := parseYtSource(tc.source)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: got %+v handle %#v page %#v, want an error", tc.source, resolved, handle, page)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.source, err)
+			continue
+		}
+		if resolved != tc.resolved || handle != tc.handle || page != tc.page {
+			t.Errorf("%s: got %+v handle %#v page %#v, want %+v handle %#v page %#v", tc.source, resolved, handle, page, tc.resolved, tc.handle, tc.page)
+		}
+	}
+}
+
+func TestResolveSourcesQuotaStop(t *testing.T) {
+	testEnv(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":403,"message":"quota","errors":[{"message":"quota","domain":"youtube.quota","reason":"quotaExceeded"}]}}`)
+	}))
+	key := YtKey
+	t.Cleanup(func() { YtKey = key })
+	YtKey = "testkey"
+
+	m := &Mirror{YtSources: []string{"@mkbhd"}}
+	_, err := m.resolveSources()
+	if !quotaStop(err) {
+		t.Errorf("got %v, want the exhausted keys error", err)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dotenv "github.com/joho/godotenv"
+	bolt "go.etcd.io/bbolt"
+)
+
+// StateStore keeps the mirror progress between runs.
+type StateStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+	Keys(prefix string) ([]string, error)
+	Close() error
+}
+
+func NewStateStore(kind, path string) (StateStore, error) {
+	switch kind {
+	case "dotenv", "":
+		if path == "" {
+			path = DotenvPath
+		}
+		return &DotenvStateStore{Path: path}, nil
+	case "json":
+		if path == "" {
+			path = "yttgchan.json"
+		}
+		return &JsonStateStore{Path: path}, nil
+	case "bolt":
+		if path == "" {
+			path = "yttgchan.db"
+		}
+		return NewBoltStateStore(path)
+	case "heroku":
+		if HerokuVarsUrl == "" || HerokuToken == "" {
+			return nil, fmt.Errorf("heroku state store requires HerokuVarsUrl and HerokuToken")
+		}
+		return &HerokuStateStore{VarsUrl: HerokuVarsUrl, Token: HerokuToken}, nil
+	}
+	return nil, fmt.Errorf("unknown state store %#v", kind)
+}
+
+func keysWithPrefix(m map[string]string, prefix string) []string {
+	var keys []string
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lockFile holds an exclusive lock on the path.lock file while fn reads and writes the file,
+// so another process, like an overlapping cron run or a status command, does not interleave with it.
+func lockFile(path string, fn func() error) error {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = flockWait(f); err != nil {
+		return fmt.Errorf("lock %s: %v", f.Name(), err)
+	}
+	defer funlock(f)
+	return fn()
+}
+
+// DotenvStateStore keeps the state in a dotenv file, the same one that is read on startup.
+type DotenvStateStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *DotenvStateStore) read() (map[string]string, error) {
+	env, err := dotenv.Read(s.Path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	return env, err
+}
+
+// update reads the file, lets fn change it and writes it back if fn reports a change, all under the locks.
+func (s *DotenvStateStore) update(fn func(env map[string]string) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return lockFile(s.Path, func() error {
+		env, err := s.read()
+		if err != nil {
+			return err
+		}
+		if !fn(env) {
+			return nil
+		}
+		return dotenv.Write(env, s.Path)
+	})
+}
+
+func (s *DotenvStateStore) Get(key string) (value string, err error) {
+	err = s.update(func(env map[string]string) bool {
+		value = env[key]
+		return false
+	})
+	return value, err
+}
+
+func (s *DotenvStateStore) Set(key, value string) error {
+	return s.update(func(env map[string]string) bool {
+		env[key] = value
+		return true
+	})
+}
+
+func (s *DotenvStateStore) Delete(key string) error {
+	return s.update(func(env map[string]string) bool {
+		if _, ok := env[key]; !ok {
+			return false
+		}
+		delete(env, key)
+		return true
+	})
+}
+
+func (s *DotenvStateStore) Keys(prefix string) (keys []string, err error) {
+	err = s.update(func(env map[string]string) bool {
+		keys = keysWithPrefix(env, prefix)
+		return false
+	})
+	return keys, err
+}
+
+func (s *DotenvStateStore) Close() error {
+	return nil
+}
+
+// JsonStateStore keeps the state as a json object in a file which is replaced atomically on every change.
+type JsonStateStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *JsonStateStore) read() (map[string]string, error) {
+	m := make(map[string]string)
+	b, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return m, nil
+	}
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("Unmarshal %s: %v", s.Path, err)
+	}
+	return m, nil
+}
+
+func (s *JsonStateStore) write(m map[string]string) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// update reads the file, lets fn change it and writes it back if fn reports a change, all under the locks.
+func (s *JsonStateStore) update(fn func(m map[string]string) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return lockFile(s.Path, func() error {
+		m, err := s.read()
+		if err != nil {
+			return err
+		}
+		if !fn(m) {
+			return nil
+		}
+		return s.write(m)
+	})
+}
+
+func (s *JsonStateStore) Get(key string) (value string, err error) {
+	err = s.update(func(m map[string]string) bool {
+		value = m[key]
+		return false
+	})
+	return value, err
+}
+
+func (s *JsonStateStore) Set(key, value string) error {
+	return s.update(func(m map[string]string) bool {
+		m[key] = value
+		return true
+	})
+}
+
+func (s *JsonStateStore) Delete(key string) error {
+	return s.update(func(m map[string]string) bool {
+		if _, ok := m[key]; !ok {
+			return false
+		}
+		delete(m, key)
+		return true
+	})
+}
+
+func (s *JsonStateStore) Keys(prefix string) (keys []string, err error) {
+	err = s.update(func(m map[string]string) bool {
+		keys = keysWithPrefix(m, prefix)
+		return false
+	})
+	return keys, err
+}
+
+func (s *JsonStateStore) Close() error {
+	return nil
+}
+
+// BoltStateStore keeps the state in a bolt key/value database file. The database is opened for every operation,
+// as bolt locks the file while it is open and another process would wait for it as long as this one runs.
+type BoltStateStore struct {
+	Path string
+}
+
+var (
+	BoltBucket  = []byte("yttgchan")
+	BoltTimeout = 10 * time.Second
+)
+
+func NewBoltStateStore(path string) (*BoltStateStore, error) {
+	s := &BoltStateStore{Path: path}
+	err := s.update(func(b *bolt.Bucket) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *BoltStateStore) open() (*bolt.DB, error) {
+	db, err := bolt.Open(s.Path, 0600, &bolt.Options{Timeout: BoltTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("bolt.Open %s: %v", s.Path, err)
+	}
+	return db, nil
+}
+
+func (s *BoltStateStore) view(fn func(b *bolt.Bucket) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(BoltBucket)
+		if b == nil {
+			return nil
+		}
+		return fn(b)
+	})
+}
+
+func (s *BoltStateStore) update(fn func(b *bolt.Bucket) error) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(BoltBucket)
+		if err != nil {
+			return fmt.Errorf("CreateBucket: %v", err)
+		}
+		return fn(b)
+	})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *BoltStateStore) Get(key string) (value string, err error) {
+	err = s.view(func(b *bolt.Bucket) error {
+		value = string(b.Get([]byte(key)))
+		return nil
+	})
+	return value, err
+}
+
+func (s *BoltStateStore) Set(key, value string) error {
+	return s.update(func(b *bolt.Bucket) error {
+		return b.Put([]byte(key), []byte(value))
+	})
+}
+
+func (s *BoltStateStore) Delete(key string) error {
+	return s.update(func(b *bolt.Bucket) error {
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *BoltStateStore) Keys(prefix string) (keys []string, err error) {
+	err = s.view(func(b *bolt.Bucket) error {
+		c := b.Cursor()
+		p := []byte(prefix)
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s *BoltStateStore) Close() error {
+	return nil
+}
+
+// HerokuStateStore keeps the state in the config vars of a heroku app.
+type HerokuStateStore struct {
+	VarsUrl string
+	Token   string
+}
+
+func (s *HerokuStateStore) do(method string, body interface{}) (map[string]string, error) {
+	var data io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, s.VarsUrl, data)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/vnd.heroku+json; version=3")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("response status: %s", resp.Status)
+	}
+
+	vars := make(map[string]string)
+	if err = json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("Decode: %v", err)
+	}
+	return vars, nil
+}
+
+func (s *HerokuStateStore) Get(key string) (string, error) {
+	vars, err := s.do("GET", nil)
+	if err != nil {
+		return "", err
+	}
+	return vars[key], nil
+}
+
+func (s *HerokuStateStore) Set(key, value string) error {
+	_, err := s.do("PATCH", map[string]string{key: value})
+	return err
+}
+
+func (s *HerokuStateStore) Delete(key string) error {
+	_, err := s.do("PATCH", map[string]interface{}{key: nil})
+	return err
+}
+
+func (s *HerokuStateStore) Keys(prefix string) ([]string, error) {
+	vars, err := s.do("GET", nil)
+	if err != nil {
+		return nil, err
+	}
+	return keysWithPrefix(vars, prefix), nil
+}
+
+func (s *HerokuStateStore) Close() error {
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// testStateRoundTrip sets, reads, lists and deletes keys of the store.
+func testStateRoundTrip(t *testing.T, s StateStore) {
+	if v, err := s.Get("YtLast"); err != nil || v != "" {
+		t.Fatalf("Get of a missing key: %#v %v", v, err)
+	}
+	for k, v := range map[string]string{"YtLast": "20210101T000000", "music.YtLast": "20210102T000000", "music.YtLedger": `{"a":"b c"}`} {
+		if err := s.Set(k, v); err != nil {
+			t.Fatalf("Set %s: %v", k, err)
+		}
+	}
+	if err := s.Set("YtLast", "20210103T000000"); err != nil {
+		t.Fatalf("Set again: %v", err)
+	}
+
+	if v, err := s.Get("YtLast"); err != nil || v != "20210103T000000" {
+		t.Errorf("Get YtLast: %#v %v", v, err)
+	}
+	if v, err := s.Get("music.YtLedger"); err != nil || v != `{"a":"b c"}` {
+		t.Errorf("Get music.YtLedger: %#v %v", v, err)
+	}
+	keys, err := s.Keys("music.")
+	if err != nil {
+		t.Fatalf("Keys: %v", err)
+	}
+	if want := []string{"music.YtLast", "music.YtLedger"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys %v, want %v", keys, want)
+	}
+
+	if err = s.Delete("music.YtLast"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err = s.Delete("missing"); err != nil {
+		t.Errorf("Delete of a missing key: %v", err)
+	}
+	if v, err := s.Get("music.YtLast"); err != nil || v != "" {
+		t.Errorf("Get of a deleted key: %#v %v", v, err)
+	}
+	if keys, err = s.Keys("music."); err != nil || !reflect.DeepEqual(keys, []string{"music.YtLedger"}) {
+		t.Errorf("Keys after Delete: %v %v", keys, err)
+	}
+	if err = s.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestDotenvStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yttgchan.env")
+	testStateRoundTrip(t, &DotenvStateStore{Path: path})
+
+	// the values are kept in the file for the next run
+	s := &DotenvStateStore{Path: path}
+	if v, err := s.Get("music.YtLedger"); err != nil || v != `{"a":"b c"}` {
+		t.Errorf("Get from the file: %#v %v", v, err)
+	}
+
+	// a file that can not be read is an error, not an empty state to write over
+	dir := t.TempDir()
+	s = &DotenvStateStore{Path: dir}
+	if _, err := s.Get("YtLast"); err == nil {
+		t.Errorf("Get of a directory: no error")
+	}
+	if err := s.Set("YtLast", "20210101T000000"); err == nil {
+		t.Errorf("Set of a directory: no error")
+	}
+}
+
+func TestJsonStateStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "yttgchan.json")
+	testStateRoundTrip(t, &JsonStateStore{Path: path})
+
+	// the file is replaced by a rename, no temporary files are left
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	if want := []string{"yttgchan.json", "yttgchan.json.lock"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files %v, want %v", names, want)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var m map[string]string
+	if err = json.Unmarshal(b, &m); err != nil || m["YtLast"] != "20210103T000000" {
+		t.Errorf("file %s: %v", b, err)
+	}
+
+	// a broken file is an error
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err = (&JsonStateStore{Path: path}).Get("YtLast"); err == nil {
+		t.Errorf("Get of a broken file: no error")
+	}
+}
+
+func TestJsonStateStoreFlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yttgchan.json")
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if err = flock(f); err != nil {
+		t.Skipf("flock: %v", err)
+	}
+
+	// another process holds the lock, the change waits for it
+	done := make(chan error)
+	go func() { done <- (&JsonStateStore{Path: path}).Set("YtLast", "20210101T000000") }()
+	select {
+	case err = <-done:
+		t.Fatalf("Set did not wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err = funlock(f); err != nil {
+		t.Fatalf("funlock: %v", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := (&JsonStateStore{Path: path}).Get("YtLast"); err != nil || v != "20210101T000000" {
+		t.Errorf("Get: %#v %v", v, err)
+	}
+}
+
+func TestBoltStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yttgchan.db")
+	s, err := NewBoltStateStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStateStore: %v", err)
+	}
+	testStateRoundTrip(t, s)
+
+	if s, err = NewBoltStateStore(path); err != nil {
+		t.Fatalf("NewBoltStateStore again: %v", err)
+	}
+	if v, err := s.Get("music.YtLedger"); err != nil || v != `{"a":"b c"}` {
+		t.Errorf("Get from the file: %#v %v", v, err)
+	}
+}
+
+// testHerokuVars serves the config vars api, a PATCH merges the vars and a null deletes one.
+type testHerokuVars struct {
+	vars    map[string]string
+	methods []string
+}
+
+func (h *testHerokuVars) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer testtoken" || r.Header.Get("Accept") != "application/vnd.heroku+json; version=3" {
+		http.Error(w, `{"id":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	h.methods = append(h.methods, r.Method)
+	switch r.Method {
+	case "GET":
+	case "PATCH":
+		var patch map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, `{"id":"bad_request"}`, http.StatusBadRequest)
+			return
+		}
+		for k, v := range patch {
+			if v == nil {
+				delete(h.vars, k)
+			} else {
+				h.vars[k] = *v
+			}
+		}
+	default:
+		http.Error(w, `{"id":"method_not_allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(h.vars)
+}
+
+func TestHerokuStateStore(t *testing.T) {
+	h := &testHerokuVars{vars: map[string]string{"TgToken": "token"}}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	testStateRoundTrip(t, &HerokuStateStore{VarsUrl: srv.URL, Token: "testtoken"})
+
+	want := map[string]string{"TgToken": "token", "YtLast": "20210103T000000", "music.YtLedger": `{"a":"b c"}`}
+	if !reflect.DeepEqual(h.vars, want) {
+		t.Errorf("vars %v, want %v", h.vars, want)
+	}
+	var patches int
+	for _, m := range h.methods {
+		if m == "PATCH" {
+			patches++
+		}
+	}
+	if patches != 6 {
+		t.Errorf("%d PATCH requests, want one per Set and Delete", patches)
+	}
+
+	_, err := (&HerokuStateStore{VarsUrl: srv.URL, Token: "badtoken"}).Get("YtLast")
+	if err == nil {
+		t.Errorf("Get with a bad token: no error")
+	}
+}
+
+func TestKeysWithPrefix(t *testing.T) {
+	keys := keysWithPrefix(map[string]string{"b.x": "", "a": "", "b.a": "", "bb": ""}, "b.")
+	if !sort.StringsAreSorted(keys) || !reflect.DeepEqual(keys, []string{"b.a", "b.x"}) {
+		t.Errorf("keys %v", keys)
+	}
+}
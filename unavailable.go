@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// The kinds of videos which can not be mirrored, the policy for each is skip or retry.
+const (
+	UnavailablePrivate       = "private"
+	UnavailableDeleted       = "deleted"
+	UnavailableRegionBlocked = "region-blocked"
+	UnavailableAgeRestricted = "age-restricted"
+	UnavailableNoThumbnails  = "no-thumbnails"
+	UnavailableTooLong       = "too-long"
+
+	PolicySkip  = "skip"
+	PolicyRetry = "retry"
+)
+
+// DefaultUnavailablePolicy retries what may come back: private videos can be made public and thumbnails can appear.
+// Too long are the videos which do not fit the upload limit in the video mode.
+var DefaultUnavailablePolicy = map[string]string{
+	UnavailablePrivate:       PolicyRetry,
+	UnavailableDeleted:       PolicySkip,
+	UnavailableRegionBlocked: PolicySkip,
+	UnavailableAgeRestricted: PolicySkip,
+	UnavailableNoThumbnails:  PolicyRetry,
+	UnavailableTooLong:       PolicySkip,
+}
+
+// YtUnavailablePolicy overrides the default policy, like "private=skip no-thumbnails=skip".
+var YtUnavailablePolicy string
+
+type VideoUnavailableError struct {
+	Kind   string
+	Reason string
+}
+
+func (err *VideoUnavailableError) Error() string {
+	return fmt.Sprintf("video %s: %s", err.Kind, err.Reason)
+}
+
+func parseUnavailablePolicy(s string) (map[string]string, error) {
+	policy := make(map[string]string)
+	for k, v := range DefaultUnavailablePolicy {
+		policy[k] = v
+	}
+	for _, f := range strings.Fields(s) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("unavailable policy %#v: expected kind=skip or kind=retry", f)
+		}
+		if _, ok := DefaultUnavailablePolicy[kv[0]]; !ok {
+			return nil, fmt.Errorf("unavailable policy %#v: unknown kind %s", f, kv[0])
+		}
+		if kv[1] != PolicySkip && kv[1] != PolicyRetry {
+			return nil, fmt.Errorf("unavailable policy %#v: expected skip or retry", f)
+		}
+		policy[kv[0]] = kv[1]
+	}
+	return policy, nil
+}
+
+// unavailableVideo tells from the listing and the details whether the video can not be mirrored.
+func unavailableVideo(vid YtPlaylistItemSnippet) error {
+	noThumbnails := vid.Thumbnails.Medium.Url == "" && vid.Thumbnails.High.Url == ""
+	switch {
+	case vid.Title == "Private video" && noThumbnails:
+		return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: "listed as private"}
+	case vid.Title == "Deleted video" && noThumbnails:
+		return &VideoUnavailableError{Kind: UnavailableDeleted, Reason: "listed as deleted"}
+	}
+	if d := vid.Details; d != nil {
+		if d.Missing {
+			return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: "not in the videos list, private or deleted"}
+		}
+		switch d.UploadStatus {
+		case "rejected", "failed", "deleted":
+			return &VideoUnavailableError{Kind: UnavailableDeleted, Reason: "upload status " + d.UploadStatus}
+		}
+		if d.PrivacyStatus == "private" {
+			return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: "privacy status private"}
+		}
+		if d.AgeRestricted {
+			return &VideoUnavailableError{Kind: UnavailableAgeRestricted, Reason: "content rating ytAgeRestricted"}
+		}
+	}
+	return nil
+}
+
+// classifyYtError makes a VideoUnavailableError or a VideoWaitingError of the playability errors,
+// other errors are returned as they are.
+func classifyYtError(err error) error {
+	var status, reason string
+	var playErr *yt.ErrPlayabiltyStatus
+	var respErr yt.ErrResponseStatus
+	switch {
+	case errors.As(err, &playErr):
+		status, reason = playErr.Status, playErr.Reason
+	case errors.As(err, &respErr):
+		status, reason = respErr.Status, respErr.Reason
+	default:
+		return err
+	}
+
+	r := strings.ToLower(reason)
+	switch {
+	case status == "LIVE_STREAM_OFFLINE" || strings.Contains(r, "premieres in") || strings.Contains(r, "live event will begin"):
+		return &VideoWaitingError{Reason: reason}
+	case status == "LOGIN_REQUIRED" && strings.Contains(r, "private"):
+		return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: reason}
+	case status == "LOGIN_REQUIRED" && (strings.Contains(r, "confirm your age") || strings.Contains(r, "inappropriate")):
+		return &VideoUnavailableError{Kind: UnavailableAgeRestricted, Reason: reason}
+	case strings.Contains(r, "country") || strings.Contains(r, "region"):
+		return &VideoUnavailableError{Kind: UnavailableRegionBlocked, Reason: reason}
+	case strings.Contains(r, "removed") || strings.Contains(r, "terminated") || strings.Contains(r, "no longer available") || r == "video unavailable":
+		return &VideoUnavailableError{Kind: UnavailableDeleted, Reason: reason}
+	case strings.Contains(r, "private"):
+		return &VideoUnavailableError{Kind: UnavailablePrivate, Reason: reason}
+	}
+	return err
+}
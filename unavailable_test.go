@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+func TestClassifyYtError(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		kind    string
+		waiting bool
+	}{
+		{err: &yt.ErrPlayabiltyStatus{Status: "LIVE_STREAM_OFFLINE", Reason: "Premieres in 3 hours"}, waiting: true},
+		{err: &yt.ErrPlayabiltyStatus{Status: "LIVE_STREAM_OFFLINE", Reason: "This live event will begin in 2 days."}, waiting: true},
+		{err: fmt.Errorf("GetVideo: %w", &yt.ErrPlayabiltyStatus{Status: "UNPLAYABLE", Reason: "Premieres in 10 minutes"}), waiting: true},
+		{err: &yt.ErrPlayabiltyStatus{Status: "LOGIN_REQUIRED", Reason: "This video is private"}, kind: UnavailablePrivate},
+		{err: &yt.ErrPlayabiltyStatus{Status: "LOGIN_REQUIRED", Reason: "Sign in to confirm your age"}, kind: UnavailableAgeRestricted},
+		{err: &yt.ErrPlayabiltyStatus{Status: "LOGIN_REQUIRED", Reason: "Sign in to view this page"}},
+		{err: &yt.ErrPlayabiltyStatus{Status: "UNPLAYABLE", Reason: "The uploader has not made this video available in your country"}, kind: UnavailableRegionBlocked},
+		{err: &yt.ErrPlayabiltyStatus{Status: "ERROR", Reason: "Video unavailable"}, kind: UnavailableDeleted},
+		{err: &yt.ErrPlayabiltyStatus{Status: "ERROR", Reason: "Something went wrong"}},
+		{err: errors.New("connection reset")},
+	} {
+		got := classifyYtError(tc.err)
+		switch cerr := got.(type) {
+		case *VideoWaitingError:
+			if !tc.waiting {
+				t.Errorf("%v: got %v", tc.err, got)
+			}
+		case *VideoUnavailableError:
+			if cerr.Kind != tc.kind {
+				t.Errorf("%v: got %v, want kind %s", tc.err, got, tc.kind)
+			}
+		default:
+			if tc.waiting || tc.kind != "" || got != tc.err {
+				t.Errorf("%v: got %v", tc.err, got)
+			}
+		}
+	}
+}
+
+func TestClassifyYtDlpError(t *testing.T) {
+	for _, tc := range []struct {
+		msg     string
+		kind    string
+		waiting bool
+	}{
+		{msg: "ERROR: [youtube] abcdefghijk: Premieres in 5 hours", waiting: true},
+		{msg: "ERROR: [youtube] abcdefghijk: This live event will begin in a few moments.", waiting: true},
+		{msg: "ERROR: [youtube] abcdefghijk: Private video. Sign in if you've been granted access to this video", kind: UnavailablePrivate},
+		{msg: "ERROR: [youtube] abcdefghijk: Sign in to confirm your age. This video may be inappropriate for some users.", kind: UnavailableAgeRestricted},
+		{msg: "ERROR: [youtube] abcdefghijk: The uploader has not made this video available in your country", kind: UnavailableRegionBlocked},
+		{msg: "ERROR: [youtube] abcdefghijk: This video has been removed by the uploader", kind: UnavailableDeleted},
+		{msg: "ERROR: unable to download video data: HTTP Error 403: Forbidden"},
+	} {
+		got := classifyYtDlpError(tc.msg)
+		switch cerr := got.(type) {
+		case *VideoWaitingError:
+			if !tc.waiting {
+				t.Errorf("%s: got %v", tc.msg, got)
+			}
+		case *VideoUnavailableError:
+			if cerr.Kind != tc.kind {
+				t.Errorf("%s: got %v, want kind %s", tc.msg, got, tc.kind)
+			}
+		default:
+			if tc.waiting || tc.kind != "" || got != nil {
+				t.Errorf("%s: got %v", tc.msg, got)
+			}
+		}
+	}
+}
+
+func TestUnavailableVideoUploadStatus(t *testing.T) {
+	for _, tc := range []struct {
+		status  string
+		kind    string
+		waiting bool
+	}{
+		{status: "processed"},
+		{status: "uploaded", waiting: true},
+		{status: "rejected", kind: UnavailableDeleted},
+		{status: "failed", kind: UnavailableDeleted},
+		{status: "deleted", kind: UnavailableDeleted},
+	} {
+		vid := YtPlaylistItemSnippet{Details: &YtVideoDetails{UploadStatus: tc.status}}
+		if waiting := vid.Details.Waiting() != ""; waiting != tc.waiting {
+			t.Errorf("%s: waiting %v, want %v", tc.status, waiting, tc.waiting)
+		}
+		err := unavailableVideo(vid)
+		if cerr, ok := err.(*VideoUnavailableError); ok && cerr.Kind != tc.kind || !ok && tc.kind != "" {
+			t.Errorf("%s: got %v, want kind %s", tc.status, err, tc.kind)
+		}
+	}
+}
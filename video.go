@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+// The modes of a mirror: the audio with the cover photo, or the video with the cover as its thumbnail.
+const (
+	TgModeAudio = "audio"
+	TgModeVideo = "video"
+)
+
+var (
+	TgMode string = TgModeAudio
+	// TgVideoMaxMb is the upload limit of the bot api, the videos are transcoded to fit it
+	TgVideoMaxMb int = 50
+	// TgVideoMaxHeight is the height the videos are downloaded or scaled down to
+	TgVideoMaxHeight int = 720
+	// TgVideoMinBitrate is the lowest video bitrate worth watching, longer videos are not mirrored
+	TgVideoMinBitrate int = 100 * 1000
+)
+
+// selectVideoFormats picks the mp4 video stream of the highest height up to TgVideoMaxHeight, h264 ones first,
+// with the audio of the mirror audio format policy, or a muxed stream when there are no video only ones.
+func (m *Mirror) selectVideoFormats(vinfo *yt.Video) (videoFormat yt.Format, audioFormat *yt.Format, err error) {
+	var video, muxed []yt.Format
+	for _, f := range vinfo.Formats {
+		switch {
+		case !strings.HasPrefix(f.MimeType, "video/mp4"):
+		case f.AudioChannels > 0:
+			muxed = append(muxed, f)
+		default:
+			video = append(video, f)
+		}
+	}
+
+	formats := video
+	if len(formats) == 0 {
+		if len(muxed) == 0 {
+			return videoFormat, nil, fmt.Errorf("no mp4 video formats in %d formats", len(vinfo.Formats))
+		}
+		log("No video only formats, falling back to %d muxed formats", len(muxed))
+		formats = muxed
+	}
+
+	formats = narrowFormats(formats, func(f yt.Format) bool { return strings.Contains(f.MimeType, "avc1") })
+	// the lowest height when none is within the limit
+	formats = narrowFormats(formats, func(f yt.Format) bool { return f.Height <= m.TgVideoMaxHeight })
+
+	for i, f := range formats {
+		switch {
+		case i == 0:
+		case videoFormat.Height <= m.TgVideoMaxHeight && f.Height > videoFormat.Height:
+		case videoFormat.Height > m.TgVideoMaxHeight && f.Height < videoFormat.Height:
+		case f.Height == videoFormat.Height && formatBitrate(f) < formatBitrate(videoFormat):
+		default:
+			continue
+		}
+		videoFormat = f
+	}
+
+	if len(video) == 0 {
+		return videoFormat, nil, nil
+	}
+	audio, err := m.selectAudioFormat(vinfo)
+	if err != nil {
+		return videoFormat, nil, err
+	}
+	return videoFormat, &audio, nil
+}
+
+// fitVideo makes an mp4 telegram can stream of the download: the file is remuxed when its streams are h264 and aac
+// and it fits TgVideoMaxMb, otherwise it is transcoded at the bitrate which fits the duration into the limit.
+func (m *Mirror) fitVideo(download *Download, src, dst string) (width, height int, err error) {
+	maxSize := int64(m.TgVideoMaxMb) * 1000 * 1000
+	width, height = download.Width, download.Height
+
+	if download.Remux && download.Stats.Size <= maxSize && height <= m.TgVideoMaxHeight {
+		log("Remuxing video %dx%d", width, height)
+		err = exec.Command(
+			FfmpegPath, "-v", "panic", "-y",
+			"-i", src, "-c", "copy",
+			"-movflags", "+faststart", dst,
+		).Run()
+		if err != nil {
+			return 0, 0, fmt.Errorf("ffmpeg: %v", err)
+		}
+		return width, height, nil
+	}
+
+	seconds := download.Duration.Seconds()
+	if seconds <= 0 {
+		return 0, 0, fmt.Errorf("video duration unknown, the bitrate can not be fitted")
+	}
+	audioBitrate, err := parseBitrate(m.TgAudioBitrate)
+	if err != nil {
+		return 0, 0, err
+	}
+	// some room for the container
+	videoBitrate := int(float64(maxSize)*8*0.95/seconds) - audioBitrate
+	if videoBitrate < TgVideoMinBitrate {
+		return 0, 0, &VideoUnavailableError{
+			Kind:   UnavailableTooLong,
+			Reason: fmt.Sprintf("duration %v does not fit %dmb, the video bitrate would be %dkbps", download.Duration, m.TgVideoMaxMb, videoBitrate/1000),
+		}
+	}
+	// no more than the source has
+	if srcBitrate := int(float64(download.Stats.Size) * 8 / seconds); videoBitrate > srcBitrate {
+		videoBitrate = srcBitrate
+	}
+
+	args := []string{"-v", "panic", "-y", "-i", src}
+	if height > m.TgVideoMaxHeight {
+		if height > 0 {
+			width = int(math.Round(float64(width)*float64(m.TgVideoMaxHeight)/float64(height)/2)) * 2
+		}
+		height = m.TgVideoMaxHeight
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:%d", height))
+	}
+	args = append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p",
+		"-b:v", strconv.Itoa(videoBitrate), "-maxrate", strconv.Itoa(videoBitrate), "-bufsize", strconv.Itoa(2*videoBitrate),
+		"-c:a", "aac", "-b:a", m.TgAudioBitrate, "-ac", "2",
+		"-movflags", "+faststart", dst,
+	)
+
+	log("Transcoding video %dx%d at %dkbps", width, height, videoBitrate/1000)
+	if err = exec.Command(FfmpegPath, args...).Run(); err != nil {
+		return 0, 0, fmt.Errorf("ffmpeg: %v", err)
+	}
+
+	fi, err := os.Stat(dst)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fi.Size() > maxSize {
+		return 0, 0, fmt.Errorf("transcoded video is %dmb, over the %dmb limit", fi.Size()/1000/1000, m.TgVideoMaxMb)
+	}
+	return width, height, nil
+}
+
+// postAsVideo posts the video with the caption and the description message, in the video mode.
+func (m *Mirror) postAsVideo(vid YtPlaylistItemSnippet, title string, entry *LedgerEntry, thumbBuf *bytes.Buffer) (err error) {
+	ytid := vid.ResourceId.VideoId
+	videoName := entry.AudioName
+
+	// yt-dlp corrects the extension of the merged file unless it is mp4
+	videoSrcFile := fmt.Sprintf("%s.mp4", videoName)
+	defer removeFile(videoSrcFile)
+	download, err := m.downloadVideo(ytid, videoSrcFile)
+	if err != nil {
+		return err
+	}
+	entry.VideoFormat = download.Format
+
+	log(
+		"Downloaded video by %s %s duration:%ds",
+		download.Downloader,
+		download.Stats,
+		int64(download.Duration.Seconds()),
+	)
+
+	videoFile := fmt.Sprintf("%s.tg.mp4", videoName)
+	defer removeFile(videoFile)
+	width, height, err := m.fitVideo(download, videoSrcFile, videoFile)
+	if err != nil {
+		return err
+	}
+	removeFile(videoSrcFile)
+
+	videoReader, err := os.Open(videoFile)
+	if err != nil {
+		return fmt.Errorf("Open %s: %v", videoFile, err)
+	}
+	defer videoReader.Close()
+	videoInfo, err := videoReader.Stat()
+	if err != nil {
+		return fmt.Errorf("Stat %s: %v", videoFile, err)
+	}
+
+	log(
+		"Final video size:%dmb %dx%d",
+		videoInfo.Size()/1000/1000, width, height,
+	)
+
+	caption, err := m.photoCaption(vid, title)
+	if err != nil {
+		return err
+	}
+
+	// nothing is posted yet, so this is the last point where an abort is clean
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			m.rollback(entry)
+		}
+	}()
+
+	tgvideo, err := tgsendVideoFile(
+		m.TgChatId,
+		videoName,
+		videoReader,
+		thumbBuf,
+		download.Duration,
+		width, height,
+	)
+	if err != nil {
+		return fmt.Errorf("tgsendVideoFile: %v", err)
+	}
+	if tgvideo.FileId == "" {
+		return fmt.Errorf("tgsendVideoFile: file_id empty")
+	}
+
+	// a lost lock or an abort cancels Ctx, stop before the next send
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	videoMsg, err := tgsendVideo(m.TgChatId, tgvideo.FileId, caption)
+	if err != nil {
+		return fmt.Errorf("tgsendVideo: %v", err)
+	}
+	entry.TgVideoMessageId = videoMsg.MessageId
+
+	if err = Ctx.Err(); err != nil {
+		return err
+	}
+	messageMsg, err := tgsendMessage(m.TgChatId, vid.Description)
+	if err != nil {
+		return fmt.Errorf("tgsendMessage: %v", err)
+	}
+	entry.TgMessageMessageId = messageMsg.MessageId
+
+	return nil
+}
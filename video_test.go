@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	yt "github.com/kkdai/youtube/v2"
+)
+
+var testVideoFormats = []yt.Format{
+	{ItagNo: 137, MimeType: `video/mp4; codecs="avc1.640028"`, Bitrate: 4000000, Width: 1920, Height: 1080},
+	{ItagNo: 298, MimeType: `video/mp4; codecs="avc1.4d4020"`, Bitrate: 2500000, Width: 1280, Height: 720},
+	{ItagNo: 136, MimeType: `video/mp4; codecs="avc1.4d401f"`, Bitrate: 1500000, Width: 1280, Height: 720},
+	{ItagNo: 398, MimeType: `video/mp4; codecs="av01.0.05M.08"`, Bitrate: 1000000, Width: 1280, Height: 720},
+	{ItagNo: 135, MimeType: `video/mp4; codecs="avc1.4d401e"`, Bitrate: 700000, Width: 854, Height: 480},
+	{ItagNo: 247, MimeType: `video/webm; codecs="vp9"`, Bitrate: 900000, Width: 1280, Height: 720},
+	{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Bitrate: 500000, AudioChannels: 2, Width: 640, Height: 360},
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AverageBitrate: 128000, AudioChannels: 2},
+	{ItagNo: 139, MimeType: `audio/mp4; codecs="mp4a.40.5"`, AverageBitrate: 48000, AudioChannels: 2},
+}
+
+var testMuxedFormats = []yt.Format{
+	{ItagNo: 22, MimeType: `video/mp4; codecs="avc1.64001F, mp4a.40.2"`, Bitrate: 1200000, AudioChannels: 2, Width: 1280, Height: 720},
+	{ItagNo: 18, MimeType: `video/mp4; codecs="avc1.42001E, mp4a.40.2"`, Bitrate: 500000, AudioChannels: 2, Width: 640, Height: 360},
+	{ItagNo: 140, MimeType: `audio/mp4; codecs="mp4a.40.2"`, AverageBitrate: 128000, AudioChannels: 2},
+}
+
+func TestSelectVideoFormats(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		formats   []yt.Format
+		maxHeight int
+		itag      int
+		audioItag int
+		err       bool
+	}{
+		{name: "720p h264 of the lowest bitrate", formats: testVideoFormats, maxHeight: 720, itag: 136, audioItag: 139},
+		{name: "1080p", formats: testVideoFormats, maxHeight: 1080, itag: 137, audioItag: 139},
+		{name: "between the heights", formats: testVideoFormats, maxHeight: 600, itag: 135, audioItag: 139},
+		{name: "none within the height", formats: testVideoFormats, maxHeight: 240, itag: 135, audioItag: 139},
+		{name: "av1 only", formats: []yt.Format{testVideoFormats[3], testVideoFormats[7]}, maxHeight: 720, itag: 398, audioItag: 140},
+		{name: "muxed fallback", formats: testMuxedFormats, maxHeight: 720, itag: 22},
+		{name: "muxed fallback lower", formats: testMuxedFormats, maxHeight: 480, itag: 18},
+		{name: "no mp4 video", formats: []yt.Format{testVideoFormats[5], testVideoFormats[7]}, maxHeight: 720, err: true},
+		{name: "no audio", formats: testVideoFormats[:5], maxHeight: 720, err: true},
+	} {
+		m := &Mirror{AudioFormat: AudioFormatPolicy{Select: "lowest", Prefer: "mp4"}, TgVideoMaxHeight: tc.maxHeight}
+		video, audio, err := m.selectVideoFormats(&yt.Video{Formats: tc.formats})
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: got %s, want an error", tc.name, describeFormat(video))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if video.ItagNo != tc.itag {
+			t.Errorf("%s: got video %s, want itag %d", tc.name, describeFormat(video), tc.itag)
+		}
+		switch {
+		case tc.audioItag == 0 && audio != nil:
+			t.Errorf("%s: got audio %s with a muxed video", tc.name, describeFormat(*audio))
+		case tc.audioItag != 0 && (audio == nil || audio.ItagNo != tc.audioItag):
+			t.Errorf("%s: got audio %v, want itag %d", tc.name, audio, tc.audioItag)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var YtApiBaseUrl = "https://www.googleapis.com/youtube/v3/"
+
+// YtKeys are the data api keys from the space separated YtKey, used in turn when one runs out of quota.
+var YtKeys []string
+
+// YtApiError is the data api error envelope.
+type YtApiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Errors  []struct {
+		Reason  string `json:"reason"`
+		Domain  string `json:"domain"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	// Details name a bad key, which errors only report as badRequest
+	Details []struct {
+		Reason string `json:"reason"`
+	} `json:"details"`
+}
+
+func (err *YtApiError) Error() string {
+	return fmt.Sprintf("youtube api %d %s: %s", err.Code, err.Reason(), err.Message)
+}
+
+func (err *YtApiError) Reason() string {
+	if len(err.Errors) == 0 {
+		return ""
+	}
+	return err.Errors[0].Reason
+}
+
+// KeyExhausted reports whether the key can not be used until the quota reset.
+func (err *YtApiError) KeyExhausted() bool {
+	switch err.Reason() {
+	case "quotaExceeded", "dailyLimitExceeded", "keyInvalid", "keyExpired":
+		return true
+	}
+	for _, d := range err.Details {
+		switch d.Reason {
+		case "API_KEY_INVALID", "API_KEY_EXPIRED":
+			return true
+		}
+	}
+	return err.Code == http.StatusBadRequest && err.Reason() == "badRequest" && strings.Contains(err.Message, "API key")
+}
+
+// YtKeysExhaustedError is returned when every key ran out of quota or is invalid.
+type YtKeysExhaustedError struct {
+	Keys  int
+	Reset time.Time
+}
+
+func (err *YtKeysExhaustedError) Error() string {
+	return fmt.Sprintf("all %d api keys are exhausted until %s", err.Keys, err.Reset.Format(time.RFC3339))
+}
+
+// quotaStop reports whether the error means no more data api calls can be made for now.
+func quotaStop(err error) bool {
+	switch err.(type) {
+	case *QuotaBudgetError, *YtKeysExhaustedError:
+		return true
+	}
+	return false
+}
+
+// keyId identifies a key in the logs and in the state store without revealing it.
+func keyId(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// quotaReset is the next midnight pacific time when the data api quota resets.
+func quotaReset(t time.Time) time.Time {
+	t = t.In(pacificTime)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, pacificTime)
+}
+
+// ytKeysExhausted are the ids of the keys exhausted on the quota date, kept in the state store as YtKeysExhausted.
+var ytKeysExhausted map[string]string
+
+func keyExhausted(key string) (bool, error) {
+	if ytKeysExhausted == nil {
+		ytKeysExhausted = make(map[string]string)
+		v, err := State.Get("YtKeysExhausted")
+		if err != nil {
+			return false, err
+		}
+		if v != "" {
+			if err = json.Unmarshal([]byte(v), &ytKeysExhausted); err != nil {
+				log("WARNING: YtKeysExhausted: %v", err)
+			}
+		}
+	}
+	return ytKeysExhausted[keyId(key)] == quotaDate(time.Now()), nil
+}
+
+func setKeyExhausted(key string) error {
+	date := quotaDate(time.Now())
+	for id, d := range ytKeysExhausted {
+		if d != date {
+			delete(ytKeysExhausted, id)
+		}
+	}
+	ytKeysExhausted[keyId(key)] = date
+	if *FlagDryRun {
+		return nil
+	}
+	b, err := json.Marshal(ytKeysExhausted)
+	if err != nil {
+		return err
+	}
+	return State.Set("YtKeysExhausted", string(b))
+}
+
+// ytGetJson calls a data api endpoint charging the quota first,
+// rotating to the next key when one is exhausted.
+func ytGetJson(endpoint string, values url.Values, target interface{}) error {
+	var budgetErr error
+	for _, key := range YtKeys {
+		exhausted, err := keyExhausted(key)
+		if err != nil {
+			return fmt.Errorf("api keys: %v", err)
+		}
+		if exhausted {
+			continue
+		}
+
+		err = Quota.Charge(endpoint, keyId(key))
+		if qerr, ok := err.(*QuotaBudgetError); ok && qerr.Budget == "day" {
+			budgetErr = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		values.Set("key", key)
+		err = ytGet(YtApiBaseUrl+endpoint+"?"+values.Encode(), target)
+		if apiErr, ok := err.(*YtApiError); ok && apiErr.KeyExhausted() {
+			log("WARNING: api key %s: %v, rotating to the next key", keyId(key), err)
+			if err := setKeyExhausted(key); err != nil {
+				return fmt.Errorf("api keys: %v", err)
+			}
+			continue
+		}
+		return err
+	}
+
+	if budgetErr != nil {
+		return budgetErr
+	}
+	return &YtKeysExhaustedError{Keys: len(YtKeys), Reset: quotaReset(time.Now())}
+}
+
+func ytGet(url string, target interface{}) error {
+	resp, err := HttpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("response status: %s: %v", resp.Status, err)
+		}
+		var envelope struct {
+			Error *YtApiError `json:"error"`
+		}
+		if err = json.Unmarshal(body, &envelope); err != nil || envelope.Error == nil {
+			return fmt.Errorf("response status: %s", resp.Status)
+		}
+		return envelope.Error
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestYtGetJsonKeyRotation(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		status    int
+		body      string
+		exhausted bool
+	}{
+		{
+			"quota exceeded", http.StatusForbidden,
+			`{"error":{"code":403,"message":"The request cannot be completed because you have exceeded your quota.","errors":[{"message":"quota","domain":"youtube.quota","reason":"quotaExceeded"}]}}`,
+			true,
+		},
+		{
+			"invalid key", http.StatusBadRequest,
+			`{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","errors":[{"message":"API key not valid. Please pass a valid API key.","domain":"global","reason":"badRequest"}],"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"API_KEY_INVALID","domain":"googleapis.com"}]}}`,
+			true,
+		},
+		{
+			"expired key", http.StatusBadRequest,
+			`{"error":{"code":400,"message":"API key expired. Please renew the API key.","errors":[{"message":"API key expired. Please renew the API key.","domain":"global","reason":"badRequest"}],"status":"INVALID_ARGUMENT","details":[{"@type":"type.googleapis.com/google.rpc.ErrorInfo","reason":"API_KEY_EXPIRED","domain":"googleapis.com"}]}}`,
+			true,
+		},
+		{
+			"invalid key without details", http.StatusBadRequest,
+			`{"error":{"code":400,"message":"API key not valid. Please pass a valid API key.","errors":[{"message":"API key not valid. Please pass a valid API key.","domain":"global","reason":"badRequest"}]}}`,
+			true,
+		},
+		{
+			"bad request", http.StatusBadRequest,
+			`{"error":{"code":400,"message":"Invalid value for parameter playlistId.","errors":[{"message":"Invalid value","domain":"youtube.parameter","reason":"invalidParameter"}]}}`,
+			false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var keys []string
+			testEnv(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := r.URL.Query().Get("key")
+				keys = append(keys, key)
+				if key == "badkey" {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(tc.status)
+					fmt.Fprint(w, tc.body)
+					return
+				}
+				fmt.Fprint(w, `{"items":[{"id":"UCchannel"}]}`)
+			}))
+			YtKeys = []string{"badkey", "goodkey"}
+
+			var channels YtChannelListResponse
+			err := ytGetJson("channels", url.Values{"id": {"UCchannel"}}, &channels)
+
+			if !tc.exhausted {
+				if _, ok := err.(*YtApiError); !ok {
+					t.Fatalf("got %v, want the api error", err)
+				}
+				if !reflect.DeepEqual(keys, []string{"badkey"}) {
+					t.Errorf("keys %v, want no rotation", keys)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ytGetJson: %v", err)
+			}
+			if len(channels.Items) != 1 {
+				t.Errorf("got %d channels, want 1", len(channels.Items))
+			}
+			if !reflect.DeepEqual(keys, []string{"badkey", "goodkey"}) {
+				t.Errorf("keys %v, want the rotation to the next key", keys)
+			}
+			if exhausted, _ := keyExhausted("badkey"); !exhausted {
+				t.Errorf("badkey is not recorded as exhausted")
+			}
+		})
+	}
+}
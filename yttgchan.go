@@ -19,16 +19,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"mime/multipart"
 	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
-	"regexp"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -59,7 +55,7 @@ const (
 )
 
 var (
-	Ctx        context.Context
+	Ctx        = context.Background()
 	HttpClient = &http.Client{}
 	YtCl       yt.Client
 
@@ -67,7 +63,9 @@ var (
 	YtUsername   string
 	YtChannelId  string
 	YtPlaylistId string
-	YtLast       string
+	YtSources    string
+	YtSource     string
+	YtFullScan   bool
 
 	TgToken        string
 	TgChatId       string
@@ -80,6 +78,12 @@ var (
 
 	HerokuToken   string
 	HerokuVarsUrl string
+
+	StateStoreKind string
+	StatePath      string
+	State          StateStore
+
+	MaxAttempts int = 5
 )
 
 type YtChannel struct {
@@ -121,6 +125,11 @@ type YtPlaylistItemSnippet struct {
 	ResourceId struct {
 		VideoId string `json:"videoId"`
 	} `json:"resourceId"`
+
+	// Playlists are the mirror playlists the video was listed in
+	Playlists []string `json:"-"`
+	// Details are from the videos list, nil without YtKey
+	Details *YtVideoDetails `json:"-"`
 }
 
 type YtPlaylistItem struct {
@@ -136,6 +145,41 @@ type YtPlaylistItems struct {
 	Items []YtPlaylistItem
 }
 
+type YtVideo struct {
+	Id             string         `json:"id"`
+	Snippet        YtVideoSnippet `json:"snippet"`
+	ContentDetails struct {
+		Duration      string `json:"duration"`
+		ContentRating struct {
+			YtRating string `json:"ytRating"`
+		} `json:"contentRating"`
+	} `json:"contentDetails"`
+	Status struct {
+		PrivacyStatus string `json:"privacyStatus"`
+		UploadStatus  string `json:"uploadStatus"`
+	} `json:"status"`
+	LiveStreamingDetails *YtLiveStreamingDetails `json:"liveStreamingDetails"`
+}
+
+type YtVideoSnippet struct {
+	YtPlaylistItemSnippet
+	Tags                 []string `json:"tags"`
+	CategoryId           string   `json:"categoryId"`
+	LiveBroadcastContent string   `json:"liveBroadcastContent"`
+	DefaultLanguage      string   `json:"defaultLanguage"`
+	DefaultAudioLanguage string   `json:"defaultAudioLanguage"`
+}
+
+type YtLiveStreamingDetails struct {
+	ActualStartTime    string `json:"actualStartTime"`
+	ActualEndTime      string `json:"actualEndTime"`
+	ScheduledStartTime string `json:"scheduledStartTime"`
+}
+
+type YtVideoListResponse struct {
+	Items []YtVideo `json:"items"`
+}
+
 type TgResponse struct {
 	Ok          bool       `json:"ok"`
 	Description string     `json:"description"`
@@ -166,23 +210,25 @@ type TgAudio struct {
 	Thumb        TgPhotoSize `json:"thumb"`
 }
 
+type TgVideo struct {
+	FileId       string      `json:"file_id"`
+	FileUniqueId string      `json:"file_unique_id"`
+	Width        int64       `json:"width"`
+	Height       int64       `json:"height"`
+	Duration     int64       `json:"duration"`
+	MimeType     string      `json:"mime_type"`
+	FileSize     int64       `json:"file_size"`
+	Thumb        TgPhotoSize `json:"thumb"`
+}
+
 type TgMessage struct {
 	Id        string
 	MessageId int64         `json:"message_id"`
 	Audio     TgAudio       `json:"audio"`
+	Video     TgVideo       `json:"video"`
 	Photo     []TgPhotoSize `json:"photo"`
 }
 
-func getJson(url string, target interface{}) error {
-	r, err := HttpClient.Get(url)
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
-
-	return json.NewDecoder(r.Body).Decode(target)
-}
-
 func postJson(url string, data *bytes.Buffer, target interface{}) error {
 	resp, err := HttpClient.Post(
 		url,
@@ -214,6 +260,9 @@ func downloadFile(url string) (*bytes.Buffer, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: response status: %s", url, resp.Status)
+	}
 
 	var bb = bytes.NewBuffer(nil)
 
@@ -225,52 +274,10 @@ func downloadFile(url string) (*bytes.Buffer, error) {
 	return bb, nil
 }
 
-func Setenv(name, value string) error {
-	if HerokuVarsUrl != "" && HerokuToken != "" {
-		return HerokuSetenv(name, value)
-	}
-
-	env, err := dotenv.Read(DotenvPath)
-	if err != nil {
-		log("WARNING: loading dotenv file: %v", err)
-		env = make(map[string]string)
-	}
-	env[name] = value
-	if err = dotenv.Write(env, DotenvPath); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func HerokuSetenv(name, value string) error {
-	req, err := http.NewRequest(
-		"PATCH",
-		HerokuVarsUrl,
-		strings.NewReader(fmt.Sprintf(`{"%s": "%s"}`, name, value)),
-	)
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Accept", "application/vnd.heroku+json; version=3")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", HerokuToken))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := HttpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("response status: %s", resp.Status)
-	}
-
-	return nil
-}
-
-func init() {
+// setup reads the environment, main calls it rather than init so the tests run without one.
+func setup() {
 	var err error
 
-	Ctx = context.TODO()
 	YtCl = yt.Client{HTTPClient: &http.Client{}}
 
 	if err = dotenv.Overload(DotenvPath); err != nil {
@@ -287,10 +294,6 @@ func init() {
 	if os.Getenv("TgChatId") != "" {
 		TgChatId = os.Getenv("TgChatId")
 	}
-	if TgChatId == "" {
-		log("ERROR: TgChatId empty")
-		os.Exit(1)
-	}
 
 	if os.Getenv("TgPerformer") != "" {
 		TgPerformer = os.Getenv("TgPerformer")
@@ -307,10 +310,7 @@ func init() {
 
 	if os.Getenv("YtKey") != "" {
 		YtKey = os.Getenv("YtKey")
-	}
-	if YtKey == "" {
-		log("ERROR: YtKey empty")
-		os.Exit(1)
+		YtKeys = strings.Fields(YtKey)
 	}
 	if os.Getenv("YtUsername") != "" {
 		YtUsername = os.Getenv("YtUsername")
@@ -321,397 +321,369 @@ func init() {
 	if os.Getenv("YtPlaylistId") != "" {
 		YtPlaylistId = os.Getenv("YtPlaylistId")
 	}
-	if os.Getenv("YtLast") != "" {
-		YtLast = os.Getenv("YtLast")
-	}
 
-	if os.Getenv("FfmpegPath") != "" {
-		FfmpegPath = os.Getenv("FfmpegPath")
+	if os.Getenv("YtSources") != "" {
+		YtSources = os.Getenv("YtSources")
 	}
-
-	HerokuToken = os.Getenv("HerokuToken")
-	if HerokuToken == "" {
-		log("WARNING: HerokuToken empty")
+	if os.Getenv("YtSource") != "" {
+		YtSource = os.Getenv("YtSource")
 	}
-	HerokuVarsUrl = os.Getenv("HerokuVarsUrl")
-	if HerokuVarsUrl == "" {
-		log("WARNING: HerokuVarsUrl empty")
+	if os.Getenv("YtFullScan") != "" {
+		YtFullScan = true
+	}
+	if os.Getenv("YtApiBaseUrl") != "" {
+		YtApiBaseUrl = os.Getenv("YtApiBaseUrl")
+	}
+	if os.Getenv("YtFeedBaseUrl") != "" {
+		YtFeedBaseUrl = os.Getenv("YtFeedBaseUrl")
+	}
+	if os.Getenv("YtFirstRun") != "" {
+		YtFirstRun = os.Getenv("YtFirstRun")
 	}
-}
 
-func main() {
-	var err error
+	if os.Getenv("TgCaptionTemplate") != "" {
+		TgCaptionTemplate = os.Getenv("TgCaptionTemplate")
+	}
 
-	if YtPlaylistId == "" {
-		if YtUsername == "" && YtChannelId == "" {
-			log("Empty YtPlaylistId and YtUsername and YtChannelId, nothing to do")
-			return
-		}
+	if os.Getenv("YtAudioSelect") != "" {
+		YtAudioSelect = os.Getenv("YtAudioSelect")
+	}
+	if os.Getenv("YtAudioPrefer") != "" {
+		YtAudioPrefer = os.Getenv("YtAudioPrefer")
+	}
+	if os.Getenv("YtAudioLanguage") != "" {
+		YtAudioLanguage = os.Getenv("YtAudioLanguage")
+	}
 
-		ChannelListUrl, err := url.Parse("https://www.googleapis.com/youtube/v3/channels")
+	if os.Getenv("TgMode") != "" {
+		TgMode = os.Getenv("TgMode")
+	}
+	if os.Getenv("TgVideoMaxMb") != "" {
+		TgVideoMaxMb, err = strconv.Atoi(os.Getenv("TgVideoMaxMb"))
 		if err != nil {
-			log("url.Parse: %v", err)
-			return
+			log("ERROR: TgVideoMaxMb: %v", err)
+			os.Exit(1)
 		}
-		ChannelListUrlValues := url.Values{}
-		ChannelListUrlValues.Set("key", YtKey)
-		ChannelListUrlValues.Set("part", "contentDetails")
-		if YtUsername != "" {
-			ChannelListUrlValues.Set("forUsername", YtUsername)
-		} else if YtChannelId != "" {
-			ChannelListUrlValues.Set("id", YtChannelId)
-		}
-		ChannelListUrl.RawQuery = ChannelListUrlValues.Encode()
-		var userChannels YtChannelListResponse
-		err = getJson(ChannelListUrl.String(), &userChannels)
+	}
+	if os.Getenv("TgVideoMaxHeight") != "" {
+		TgVideoMaxHeight, err = strconv.Atoi(os.Getenv("TgVideoMaxHeight"))
 		if err != nil {
-			log("Failed to get channels list: %v", err)
-			return
-		}
-
-		if len(userChannels.Items) == 0 {
-			log("Empty channels list")
-			return
-		}
-		YtPlaylistId = userChannels.Items[0].ContentDetails.RelatedPlaylists.Uploads
-		if YtPlaylistId == "" {
-			log("Empty playlist id was retrieved")
-			return
+			log("ERROR: TgVideoMaxHeight: %v", err)
+			os.Exit(1)
 		}
 	}
 
-	var videos []YtPlaylistItemSnippet
-
-	for _, plid := range strings.Split(YtPlaylistId, " ") {
-		if plid == "" {
-			continue
-		}
-		nextPageToken := ""
-		for nextPageToken != "" || len(videos) == 0 {
-			var PlaylistItemsUrl = fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlistItems?maxResults=%d&part=snippet&playlistId=%s&key=%s&pageToken=%s", YtMaxResults, plid, YtKey, nextPageToken)
-
-			var playlistItems YtPlaylistItems
-			err = getJson(PlaylistItemsUrl, &playlistItems)
-			if err != nil {
-				log("Failed to get playlist items: %v", err)
-				return
-			}
-
-			if playlistItems.NextPageToken != nextPageToken {
-				nextPageToken = playlistItems.NextPageToken
-			} else {
-				nextPageToken = ""
-			}
-
-			for _, i := range playlistItems.Items {
-				videos = append(videos, i.Snippet)
-			}
-		}
+	if os.Getenv("YtDownloaders") != "" {
+		YtDownloaders = os.Getenv("YtDownloaders")
+	}
+	if os.Getenv("YtDlpPath") != "" {
+		YtDlpPath = os.Getenv("YtDlpPath")
+	}
+	if os.Getenv("YtDlpArgs") != "" {
+		YtDlpArgs = os.Getenv("YtDlpArgs")
 	}
 
-	log("Videos: %d", len(videos))
-
-	sort.Slice(videos, func(i, j int) bool { return videos[i].PublishedAt < videos[j].PublishedAt })
-
-	for vidnum, vid := range videos {
-		var ytid, publishedAt string
-		var title, description string
-		var audioName string
-
-		ytid = vid.ResourceId.VideoId
-
-		publishedAt = strings.NewReplacer("-", "", "T", ".", ":", "").Replace(vid.PublishedAt)
-		publishedAt = strings.TrimSuffix(publishedAt, "Z")
-		publishedAt = strings.TrimSuffix(publishedAt, ".000")
-
-		title = vid.Title
-		if TgTitleCleanRe != "" {
-			title = regexp.MustCompile(TgTitleCleanRe).ReplaceAllString(title, "")
-		}
-		if TgTitleUnquote {
-			if strings.HasPrefix(title, `"`) && strings.HasSuffix(title, `"`) {
-				title = strings.Trim(title, `"`)
-			}
-			if strings.HasPrefix(title, `«`) && strings.HasSuffix(title, `»`) {
-				title = strings.Trim(title, `«`)
-				title = strings.Trim(title, `»`)
-			}
-			for strings.Contains(title, `"`) {
-				title = strings.Replace(title, `"`, `«`, 1)
-				title = strings.Replace(title, `"`, `»`, 1)
-			}
-		}
-
-		description = vid.Description
-
-		audioName = fmt.Sprintf("%s.%s", publishedAt, ytid)
-
-		if audioName == YtLast {
-			log("Last: %s: #%d %s", YtLast, vidnum+1, title)
-		}
-
-		if audioName <= YtLast {
-			continue
-		}
-
-		log("New: #%d %s: %s", vidnum+1, audioName, title)
-		log("Description: %d letters", len([]rune(description)))
+	if os.Getenv("YtUnavailablePolicy") != "" {
+		YtUnavailablePolicy = os.Getenv("YtUnavailablePolicy")
+	}
 
-		var coverUrl, thumbUrl string
-		var coverBuf, thumbBuf, audioBuf *bytes.Buffer
+	if os.Getenv("YtFilter") != "" {
+		YtFilter = os.Getenv("YtFilter")
+	}
 
-		coverUrl = vid.Thumbnails.MaxRes.Url
-		if coverUrl == "" {
-			coverUrl = vid.Thumbnails.Standard.Url
-		}
-		if coverUrl == "" {
-			coverUrl = vid.Thumbnails.High.Url
-		}
-		if coverUrl == "" {
-			coverUrl = vid.Thumbnails.Medium.Url
+	if os.Getenv("YtQuotaDayBudget") != "" {
+		YtQuotaDayBudget, err = strconv.Atoi(os.Getenv("YtQuotaDayBudget"))
+		if err != nil {
+			log("ERROR: YtQuotaDayBudget: %v", err)
+			os.Exit(1)
 		}
-		if coverUrl == "" {
-			log("No cover url")
-			break
+	}
+	if os.Getenv("YtQuotaRunBudget") != "" {
+		YtQuotaRunBudget, err = strconv.Atoi(os.Getenv("YtQuotaRunBudget"))
+		if err != nil {
+			log("ERROR: YtQuotaRunBudget: %v", err)
+			os.Exit(1)
 		}
+	}
 
-		thumbUrl = vid.Thumbnails.Medium.Url
-		if thumbUrl == "" {
-			log("No thumb url")
-			break
-		}
+	if os.Getenv("FfmpegPath") != "" {
+		FfmpegPath = os.Getenv("FfmpegPath")
+	}
 
-		coverBuf, err = downloadFile(coverUrl)
+	if os.Getenv("DaemonInterval") != "" {
+		DaemonInterval, err = time.ParseDuration(os.Getenv("DaemonInterval"))
 		if err != nil {
-			log("Download cover: %v", err)
-			break
+			log("ERROR: DaemonInterval: %v", err)
+			os.Exit(1)
 		}
-		log(
-			"Cover: %dkb",
-			coverBuf.Len()/1000,
-		)
-
-		thumbBuf, err = downloadFile(thumbUrl)
+	}
+	if os.Getenv("DaemonJitter") != "" {
+		DaemonJitter, err = time.ParseDuration(os.Getenv("DaemonJitter"))
 		if err != nil {
-			log("Download thumb: %v", err)
-			break
+			log("ERROR: DaemonJitter: %v", err)
+			os.Exit(1)
 		}
-		log(
-			"Thumb: %dkb",
-			thumbBuf.Len()/1000,
-		)
+	}
 
-		vinfo, err := YtCl.GetVideoContext(Ctx, ytid)
+	if os.Getenv("MaxAttempts") != "" {
+		MaxAttempts, err = strconv.Atoi(os.Getenv("MaxAttempts"))
 		if err != nil {
-			log("GetVideoContext: %v", err)
-			break
-		}
-
-		var audioFormat yt.Format
-		for _, f := range vinfo.Formats {
-			if !strings.HasPrefix(f.MimeType, "audio/mp4") {
-				continue
-			}
-			if audioFormat.Bitrate == 0 || f.Bitrate < audioFormat.Bitrate {
-				audioFormat = f
-			}
+			log("ERROR: MaxAttempts: %v", err)
+			os.Exit(1)
 		}
+	}
 
-		ytstream, _, err := YtCl.GetStreamContext(Ctx, vinfo, &audioFormat)
-		if err != nil {
-			log("GetStreamContext: %v", err)
-			break
-		}
-		defer ytstream.Close()
+	HerokuToken = os.Getenv("HerokuToken")
+	if HerokuToken == "" {
+		log("WARNING: HerokuToken empty")
+	}
+	HerokuVarsUrl = os.Getenv("HerokuVarsUrl")
+	if HerokuVarsUrl == "" {
+		log("WARNING: HerokuVarsUrl empty")
+	}
 
-		audioBuf = bytes.NewBuffer(nil)
-		_, err = io.Copy(audioBuf, ytstream)
-		if err != nil {
-			break
-		}
+	StateStoreKind = os.Getenv("StateStore")
+	if StateStoreKind == "" && HerokuVarsUrl != "" && HerokuToken != "" {
+		StateStoreKind = "heroku"
+	}
+	if StateStoreKind == "heroku" {
+		log("WARNING: the heroku state store keeps the ledger in a config var, every change is an app release and all the vars share 32kb, so only the videos in flight or failed are kept")
+	}
+	StatePath = os.Getenv("StatePath")
+	State, err = NewStateStore(StateStoreKind, StatePath)
+	if err != nil {
+		log("ERROR: state store: %v", err)
+		os.Exit(1)
+	}
 
-		log(
-			"Downloaded audio size:%dmb bitrate:%dkbps duration:%ds",
-			audioBuf.Len()/1000/1000,
-			audioFormat.Bitrate/1024,
-			int64(vinfo.Duration.Seconds()),
-		)
-		if audioBuf.Len()/1000/1000 < 1 {
-			log("Downloaded audio less than one megabyte, something is wrong, aborting.")
-			break
+	// on the heroku store every lease change would be a config var change, which makes a release and restarts the dynos
+	if StateStoreKind == "heroku" {
+		LockKind = "file"
+	}
+	if os.Getenv("LockKind") != "" {
+		LockKind = os.Getenv("LockKind")
+	}
+	if StateStoreKind == "heroku" && (LockKind == "state" || LockKind == "") {
+		log("WARNING: LockKind state on the heroku state store makes an app release and restarts the dynos on every lock acquire, renew and release")
+	}
+	if os.Getenv("LockDir") != "" {
+		LockDir = os.Getenv("LockDir")
+	}
+	if os.Getenv("LockTtl") != "" {
+		LockTtl, err = time.ParseDuration(os.Getenv("LockTtl"))
+		if err != nil || LockTtl < time.Minute {
+			log("ERROR: LockTtl must be a duration of at least one minute: %#v", os.Getenv("LockTtl"))
+			os.Exit(1)
 		}
+	}
+	LockOwner = lockOwner()
+	Locks, err = NewLocker(LockKind)
+	if err != nil {
+		log("ERROR: lock: %v", err)
+		os.Exit(1)
+	}
 
-		audioSrcFile := fmt.Sprintf("%s.m4a", audioName)
-		err = ioutil.WriteFile(audioSrcFile, audioBuf.Bytes(), 0400)
+	ConfigPath = os.Getenv("ConfigPath")
+	if ConfigPath != "" {
+		Mirrors, err = LoadConfig(ConfigPath)
 		if err != nil {
-			log("WriteFile %s: %v", audioSrcFile, err)
-			break
+			log("ERROR: config: %v", err)
+			os.Exit(1)
 		}
-
-		audioFile := fmt.Sprintf("%s.%s.m4a", audioName, TgAudioBitrate)
-		err = exec.Command(
-			FfmpegPath, "-v", "panic",
-			"-i", audioSrcFile,
-			"-b:a", TgAudioBitrate, audioFile,
-		).Run()
+	} else {
+		m, err := EnvMirror()
 		if err != nil {
-			log("ffmpeg: %v", err)
-			break
+			log("ERROR: %v", err)
+			os.Exit(1)
 		}
-
-		err = os.Remove(audioSrcFile)
-		if err != nil {
-			log("Remove %s: %v", audioSrcFile, err)
+		Mirrors = []*Mirror{m}
+	}
+	for _, m := range Mirrors {
+		if err = m.Init(); err != nil {
+			log("ERROR: mirror %s: %v", m.Name, err)
+			os.Exit(1)
 		}
+	}
+}
 
-		abb, err := ioutil.ReadFile(audioFile)
-		if err != nil {
-			log("ReadFile %s: %v", audioFile, err)
-			break
-		}
-		audioBuf = bytes.NewBuffer(abb)
+func main() {
+	setup()
 
-		log(
-			"Final converted audio size:%dmb bitrate:%sbps",
-			audioBuf.Len()/1000/1000, TgAudioBitrate,
-		)
+	flag.Usage = usage
+	flag.Parse()
 
-		err = os.Remove(audioFile)
-		if err != nil {
-			log("Remove %s: %v", audioFile, err)
-		}
+	err := command(flag.Args())
+	if qerr := Quota.Save(); qerr != nil {
+		log("ERROR: quota save: %v", qerr)
+	}
+	State.Close()
+	if err != nil {
+		log("ERROR: %v", err)
+		os.Exit(1)
+	}
+}
 
-		tgcover, err := tgsendPhotoFile(audioName, coverBuf, title)
-		if err != nil {
-			log("tgsendPhotoFile: %v", err)
-			break
-		}
-		if tgcover.FileId == "" {
-			log("tgsendPhotoFile: file_id empty")
-			break
-		}
+// tgsendAudioFile streams the multipart body through a pipe, so the audio is never held in memory whole.
+func tgsendAudioFile(chatid string, performer, title string, fileName string, audioReader io.Reader, thumbBuf *bytes.Buffer, duration time.Duration) (audio *TgAudio, err error) {
+	mpartReader, mpartWriter := io.Pipe()
+	mpart := multipart.NewWriter(mpartWriter)
 
-		tgaudio, err := tgsendAudioFile(
-			TgPerformer,
-			title,
-			audioName,
-			audioBuf,
-			thumbBuf,
-			vinfo.Duration,
-		)
-		if err != nil {
-			log("tgsendAudioFile: %v", err)
-			break
-		}
-		if tgaudio.FileId == "" {
-			log("tgsendAudioFile: file_id empty")
-			break
-		}
+	go func() {
+		mpartWriter.CloseWithError(writeAudioForm(mpart, chatid, performer, title, fileName, audioReader, thumbBuf, duration))
+	}()
+	defer mpartReader.Close()
 
-		_, err = tgsendPhoto(tgcover.FileId, title)
-		if err != nil {
-			log("tgsendPhoto: %v", err)
-			break
-		}
+	resp, err := HttpClient.Post(
+		fmt.Sprintf("https://api.telegram.org/bot%s/sendAudio", TgToken),
+		mpart.FormDataContentType(),
+		mpartReader,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Post: %v", err)
+	}
+	defer resp.Body.Close()
 
-		_, err = tgsendAudio(tgaudio.FileId)
-		if err != nil {
-			log("tgsendAudio: %v", err)
-			break
-		}
+	var tgresp TgResponse
+	err = json.NewDecoder(resp.Body).Decode(&tgresp)
+	if err != nil {
+		return nil, fmt.Errorf("Decode: %v", err)
+	}
+	if !tgresp.Ok {
+		return nil, fmt.Errorf("sendAudio: %s", tgresp.Description)
+	}
 
-		_, err = tgsendMessage(description)
-		if err != nil {
-			log("tgsendMessage: %v", err)
-			break
-		}
+	msg := tgresp.Result
+	msg.Id = fmt.Sprintf("%d", msg.MessageId)
 
-		err = Setenv("YtLast", audioName)
-		if err != nil {
-			log("Setenv YtLast: %v", err)
-			break
-		}
+	audio = &msg.Audio
 
-		log("#%d uploaded", vidnum+1)
+	if audio.FileId == "" {
+		return nil, fmt.Errorf("sendAudio: Audio.FileId empty")
 	}
 
-	return
+	err = tgdeleteMessage(chatid, msg.MessageId)
+	if err != nil {
+		return nil, fmt.Errorf("tgdeleteMessage(%d): %v", msg.MessageId, err)
+	}
+
+	return audio, nil
 }
 
-func tgsendAudioFile(performer, title string, fileName string, audioBuf, thumbBuf *bytes.Buffer, duration time.Duration) (audio *TgAudio, err error) {
-	var mpartBuf bytes.Buffer
-	mpart := multipart.NewWriter(&mpartBuf)
+func writeAudioForm(mpart *multipart.Writer, chatid string, performer, title string, fileName string, audioReader io.Reader, thumbBuf *bytes.Buffer, duration time.Duration) (err error) {
 	var formWr io.Writer
 
 	// chat_id
 	formWr, err = mpart.CreateFormField("chat_id")
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormField(`chat_id`): %v", err)
+		return fmt.Errorf("CreateFormField(`chat_id`): %v", err)
 	}
-	_, err = formWr.Write([]byte(TgChatId))
+	_, err = formWr.Write([]byte(chatid))
 	if err != nil {
-		return nil, fmt.Errorf("Write(chat_id): %v", err)
+		return fmt.Errorf("Write(chat_id): %v", err)
 	}
 
 	// performer
 	formWr, err = mpart.CreateFormField("performer")
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormField(`performer`): %v", err)
+		return fmt.Errorf("CreateFormField(`performer`): %v", err)
 	}
 	_, err = formWr.Write([]byte(performer))
 	if err != nil {
-		return nil, fmt.Errorf("Write(performer): %v", err)
+		return fmt.Errorf("Write(performer): %v", err)
 	}
 
 	// title
 	formWr, err = mpart.CreateFormField("title")
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormField(`title`): %v", err)
+		return fmt.Errorf("CreateFormField(`title`): %v", err)
 	}
 	_, err = formWr.Write([]byte(title))
 	if err != nil {
-		return nil, fmt.Errorf("Write(title): %v", err)
+		return fmt.Errorf("Write(title): %v", err)
 	}
 
 	// audio
 	formWr, err = mpart.CreateFormFile("audio", fileName)
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormFile('audio'): %v", err)
+		return fmt.Errorf("CreateFormFile('audio'): %v", err)
 	}
-	_, err = io.Copy(formWr, audioBuf)
+	_, err = io.Copy(formWr, audioReader)
 	if err != nil {
-		return nil, fmt.Errorf("Copy audio: %v", err)
+		return fmt.Errorf("Copy audio: %v", err)
 	}
 
 	// thumb
 	formWr, err = mpart.CreateFormFile("thumb", fileName+".thumb")
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormFile(`thumb`): %v", err)
+		return fmt.Errorf("CreateFormFile(`thumb`): %v", err)
 	}
 	_, err = io.Copy(formWr, thumbBuf)
 	if err != nil {
-		return nil, fmt.Errorf("Copy thumb: %v", err)
+		return fmt.Errorf("Copy thumb: %v", err)
 	}
 
 	// duration
 	formWr, err = mpart.CreateFormField("duration")
 	if err != nil {
-		return nil, fmt.Errorf("CreateFormField(`duration`): %v", err)
+		return fmt.Errorf("CreateFormField(`duration`): %v", err)
 	}
 	_, err = formWr.Write([]byte(strconv.Itoa(int(duration.Seconds()))))
 	if err != nil {
-		return nil, fmt.Errorf("Write(duration): %v", err)
+		return fmt.Errorf("Write(duration): %v", err)
 	}
 
 	err = mpart.Close()
 	if err != nil {
-		return nil, fmt.Errorf("multipartWriter.Close: %v", err)
+		return fmt.Errorf("multipartWriter.Close: %v", err)
 	}
 
-	resp, err := HttpClient.Post(
+	return nil
+}
+
+func tgsendAudio(chatid string, fileid string) (msg *TgMessage, err error) {
+	sendAudio := map[string]interface{}{
+		"chat_id": chatid,
+		"audio":   fileid,
+	}
+	sendAudioJSON, err := json.Marshal(sendAudio)
+	if err != nil {
+		return nil, err
+	}
+
+	var tgresp TgResponse
+	err = postJson(
 		fmt.Sprintf("https://api.telegram.org/bot%s/sendAudio", TgToken),
+		bytes.NewBuffer(sendAudioJSON),
+		&tgresp,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tgresp.Ok {
+		return nil, fmt.Errorf("sendAudio: %s", tgresp.Description)
+	}
+
+	msg = tgresp.Result
+	msg.Id = fmt.Sprintf("%d", msg.MessageId)
+
+	return msg, nil
+}
+
+// tgsendVideoFile uploads the video like tgsendAudioFile, streaming it through a pipe.
+func tgsendVideoFile(chatid string, fileName string, videoReader io.Reader, thumbBuf *bytes.Buffer, duration time.Duration, width, height int) (video *TgVideo, err error) {
+	mpartReader, mpartWriter := io.Pipe()
+	mpart := multipart.NewWriter(mpartWriter)
+
+	go func() {
+		mpartWriter.CloseWithError(writeVideoForm(mpart, chatid, fileName, videoReader, thumbBuf, duration, width, height))
+	}()
+	defer mpartReader.Close()
+
+	resp, err := HttpClient.Post(
+		fmt.Sprintf("https://api.telegram.org/bot%s/sendVideo", TgToken),
 		mpart.FormDataContentType(),
-		&mpartBuf,
+		mpartReader,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("Post: %v", err)
@@ -724,40 +696,92 @@ func tgsendAudioFile(performer, title string, fileName string, audioBuf, thumbBu
 		return nil, fmt.Errorf("Decode: %v", err)
 	}
 	if !tgresp.Ok {
-		return nil, fmt.Errorf("sendAudio: %s", tgresp.Description)
+		return nil, fmt.Errorf("sendVideo: %s", tgresp.Description)
 	}
 
 	msg := tgresp.Result
 	msg.Id = fmt.Sprintf("%d", msg.MessageId)
 
-	audio = &msg.Audio
+	video = &msg.Video
 
-	if audio.FileId == "" {
-		return nil, fmt.Errorf("sendAudio: Audio.FileId empty")
+	if video.FileId == "" {
+		return nil, fmt.Errorf("sendVideo: Video.FileId empty")
 	}
 
-	err = tgdeleteMessage(msg.MessageId)
+	err = tgdeleteMessage(chatid, msg.MessageId)
 	if err != nil {
 		return nil, fmt.Errorf("tgdeleteMessage(%d): %v", msg.MessageId, err)
 	}
 
-	return audio, nil
+	return video, nil
 }
 
-func tgsendAudio(fileid string) (msg *TgMessage, err error) {
-	sendAudio := map[string]interface{}{
-		"chat_id": TgChatId,
-		"audio":   fileid,
+func writeVideoForm(mpart *multipart.Writer, chatid string, fileName string, videoReader io.Reader, thumbBuf *bytes.Buffer, duration time.Duration, width, height int) (err error) {
+	var formWr io.Writer
+
+	for _, field := range []struct{ name, value string }{
+		{"chat_id", chatid},
+		{"duration", strconv.Itoa(int(duration.Seconds()))},
+		{"width", strconv.Itoa(width)},
+		{"height", strconv.Itoa(height)},
+		{"supports_streaming", "true"},
+	} {
+		formWr, err = mpart.CreateFormField(field.name)
+		if err != nil {
+			return fmt.Errorf("CreateFormField(`%s`): %v", field.name, err)
+		}
+		_, err = formWr.Write([]byte(field.value))
+		if err != nil {
+			return fmt.Errorf("Write(%s): %v", field.name, err)
+		}
 	}
-	sendAudioJSON, err := json.Marshal(sendAudio)
+
+	// thumb
+	formWr, err = mpart.CreateFormFile("thumb", fileName+".thumb")
+	if err != nil {
+		return fmt.Errorf("CreateFormFile(`thumb`): %v", err)
+	}
+	_, err = io.Copy(formWr, thumbBuf)
+	if err != nil {
+		return fmt.Errorf("Copy thumb: %v", err)
+	}
+
+	// video
+	formWr, err = mpart.CreateFormFile("video", fileName+".mp4")
+	if err != nil {
+		return fmt.Errorf("CreateFormFile('video'): %v", err)
+	}
+	_, err = io.Copy(formWr, videoReader)
+	if err != nil {
+		return fmt.Errorf("Copy video: %v", err)
+	}
+
+	err = mpart.Close()
+	if err != nil {
+		return fmt.Errorf("multipartWriter.Close: %v", err)
+	}
+
+	return nil
+}
+
+func tgsendVideo(chatid string, fileid, caption string) (msg *TgMessage, err error) {
+	sendVideo := map[string]interface{}{
+		"chat_id":    chatid,
+		"video":      fileid,
+		"caption":    caption,
+		"parse_mode": "HTML",
+
+		"supports_streaming": true,
+	}
+	sendVideoJSON, err := json.Marshal(sendVideo)
 	if err != nil {
 		return nil, err
 	}
 
 	var tgresp TgResponse
 	err = postJson(
-		fmt.Sprintf("https://api.telegram.org/bot%s/sendAudio", TgToken),
-		bytes.NewBuffer(sendAudioJSON),
+		fmt.Sprintf("https://api.telegram.org/bot%s/sendVideo", TgToken),
+		bytes.NewBuffer(sendVideoJSON),
 		&tgresp,
 	)
 	if err != nil {
@@ -765,7 +789,7 @@ func tgsendAudio(fileid string) (msg *TgMessage, err error) {
 	}
 
 	if !tgresp.Ok {
-		return nil, fmt.Errorf("sendAudio: %s", tgresp.Description)
+		return nil, fmt.Errorf("sendVideo: %s", tgresp.Description)
 	}
 
 	msg = tgresp.Result
@@ -774,7 +798,7 @@ func tgsendAudio(fileid string) (msg *TgMessage, err error) {
 	return msg, nil
 }
 
-func tgsendPhotoFile(fileName string, photoBuf *bytes.Buffer, caption string) (photo *TgPhotoSize, err error) {
+func tgsendPhotoFile(chatid string, fileName string, photoBuf *bytes.Buffer, caption string) (photo *TgPhotoSize, err error) {
 	var mpartBuf bytes.Buffer
 	mpart := multipart.NewWriter(&mpartBuf)
 	var formWr io.Writer
@@ -784,7 +808,7 @@ func tgsendPhotoFile(fileName string, photoBuf *bytes.Buffer, caption string) (p
 	if err != nil {
 		return nil, fmt.Errorf("CreateFormField(`chat_id`): %v", err)
 	}
-	_, err = formWr.Write([]byte(TgChatId))
+	_, err = formWr.Write([]byte(chatid))
 	if err != nil {
 		return nil, fmt.Errorf("Write(chat_id): %v", err)
 	}
@@ -841,7 +865,7 @@ func tgsendPhotoFile(fileName string, photoBuf *bytes.Buffer, caption string) (p
 		return nil, fmt.Errorf("sendPhoto: Photo.FileId empty")
 	}
 
-	err = tgdeleteMessage(msg.MessageId)
+	err = tgdeleteMessage(chatid, msg.MessageId)
 	if err != nil {
 		return nil, fmt.Errorf("tgdeleteMessage(%d): %v", msg.MessageId, err)
 	}
@@ -849,10 +873,9 @@ func tgsendPhotoFile(fileName string, photoBuf *bytes.Buffer, caption string) (p
 	return photo, nil
 }
 
-func tgsendPhoto(fileid, caption string) (msg *TgMessage, err error) {
-	caption = fmt.Sprintf("<u><b>%s</b></u>", caption)
+func tgsendPhoto(chatid string, fileid, caption string) (msg *TgMessage, err error) {
 	sendPhoto := map[string]interface{}{
-		"chat_id":    TgChatId,
+		"chat_id":    chatid,
 		"photo":      fileid,
 		"caption":    caption,
 		"parse_mode": "HTML",
@@ -882,9 +905,9 @@ func tgsendPhoto(fileid, caption string) (msg *TgMessage, err error) {
 	return msg, nil
 }
 
-func tgsendMessage(message string) (msg *TgMessage, err error) {
+func tgsendMessage(chatid string, message string) (msg *TgMessage, err error) {
 	sendMessage := map[string]interface{}{
-		"chat_id": TgChatId,
+		"chat_id": chatid,
 		"text":    message,
 
 		"disable_web_page_preview": true,
@@ -914,9 +937,9 @@ func tgsendMessage(message string) (msg *TgMessage, err error) {
 	return msg, nil
 }
 
-func tgdeleteMessage(messageid int64) error {
+func tgdeleteMessage(chatid string, messageid int64) error {
 	deleteMessage := map[string]interface{}{
-		"chat_id":    TgChatId,
+		"chat_id":    chatid,
 		"message_id": messageid,
 	}
 	deleteMessageJSON, err := json.Marshal(deleteMessage)
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// testEnv points the state store at a temporary file and the api and feed base urls at the handler,
+// restoring the globals when the test ends.
+func testEnv(t *testing.T, handler http.Handler) *httptest.Server {
+	srv := httptest.NewServer(handler)
+
+	state, quota, keys, apiBaseUrl, feedBaseUrl := State, Quota, YtKeys, YtApiBaseUrl, YtFeedBaseUrl
+	t.Cleanup(func() {
+		srv.Close()
+		State, Quota, YtKeys, YtApiBaseUrl, YtFeedBaseUrl = state, quota, keys, apiBaseUrl, feedBaseUrl
+		ytKeysExhausted = nil
+	})
+
+	State = &JsonStateStore{Path: filepath.Join(t.TempDir(), "yttgchan.json")}
+	Quota = &QuotaMeter{}
+	YtKeys = []string{"testkey"}
+	ytKeysExhausted = nil
+	YtApiBaseUrl = srv.URL + "/youtube/v3/"
+	YtFeedBaseUrl = srv.URL + "/feeds/videos.xml"
+
+	return srv
+}